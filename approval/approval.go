@@ -0,0 +1,186 @@
+// Package approval holds back new certificate identities of CAs until
+// they are manually approved.
+package approval
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/secrets"
+	"github.com/pkg/errors"
+
+	"docker-secretprovider-pki/driver"
+	"docker-secretprovider-pki/duration"
+)
+
+// DefaultFile is the name of the approval file in plugin's config directory.
+const DefaultFile = "approval.json"
+
+// DefaultDir is the directory in plugin's config directory approval records
+// are kept in.
+const DefaultDir = "approvals"
+
+// DefaultTTL specifies how long approvals are valid by default.
+const DefaultTTL = 30 * 24 * time.Hour
+
+// CAConfig configures the approval mode of a CA.
+type CAConfig struct {
+	// TTL specifies how long an approval is valid.
+	TTL duration.Duration `json:"ttl"`
+}
+
+// Gate requires approval of new certificate identities of the configured
+// CAs. Requests for other CAs pass the gate.
+type Gate struct {
+	CAs map[string]*CAConfig `json:"cas"`
+
+	store *Store
+}
+
+// Load reads the approval configuration from a JSON file.
+func Load(filename string, store *Store) (*Gate, error) {
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading approval file")
+	}
+
+	return Parse(raw, store)
+}
+
+// Parse parses and validates a JSON encoded approval configuration.
+func Parse(raw []byte, store *Store) (*Gate, error) {
+	g := &Gate{store: store}
+	if err := json.Unmarshal(raw, g); err != nil {
+		return nil, errors.Wrap(err, "error parsing approval configuration")
+	}
+
+	for name, ca := range g.CAs {
+		if ca == nil {
+			ca = &CAConfig{}
+			g.CAs[name] = ca
+		}
+
+		if ca.TTL.Duration < 0 {
+			return nil, errors.Errorf("invalid approval for CA %s: TTL can not be negative", name)
+		}
+
+		if ca.TTL.Duration == 0 {
+			ca.TTL.Duration = DefaultTTL
+		}
+	}
+
+	return g, nil
+}
+
+// Check passes requests with a valid approval. Requests without one are
+// recorded as pending approval.
+func (g *Gate) Check(request secrets.Request, config driver.CertRequest) error {
+	ca, exists := g.CAs[config.CAName]
+	if !exists {
+		return nil
+	}
+
+	id := Identity(request, config)
+
+	// The record is locked, so that concurrent requests of an identity
+	// and decisions don't overwrite each other's records.
+	unlock, err := g.store.Lock(id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	record, err := g.store.Get(id)
+	if err == ErrNotFound {
+		record = newRecord(id, request, config)
+		if err := g.store.Put(record); err != nil {
+			return err
+		}
+
+		return errors.Errorf("request %s is pending approval", id)
+	} else if err != nil {
+		return err
+	}
+
+	switch record.Status {
+	case StatusApproved:
+		if record.DecidedAt != nil && time.Now().Before(record.DecidedAt.Add(ca.TTL.Duration)) {
+			return nil
+		}
+
+		// Expired approvals must be approved again.
+		record = newRecord(id, request, config)
+		if err := g.store.Put(record); err != nil {
+			return err
+		}
+
+		return errors.Errorf("approval of request %s expired, the request is pending approval again", id)
+	case StatusDenied:
+		if record.Reason == "" {
+			return errors.Errorf("request %s was denied", id)
+		}
+
+		return errors.Errorf("request %s was denied: %s", id, record.Reason)
+	default:
+		return errors.Errorf("request %s is pending approval", id)
+	}
+}
+
+func newRecord(id string, request secrets.Request, config driver.CertRequest) *Record {
+	record := &Record{
+		ID:          id,
+		CA:          config.CAName,
+		Secret:      request.SecretName,
+		Service:     request.ServiceName,
+		CommonName:  config.CommonName,
+		DNSNames:    config.DNSNames,
+		Status:      StatusPending,
+		RequestedAt: time.Now(),
+	}
+
+	for _, uri := range config.URIs {
+		record.URIs = append(record.URIs, uri.String())
+	}
+
+	if config.SPIFFEID != nil {
+		record.URIs = append(record.URIs, config.SPIFFEID.String())
+	}
+
+	return record
+}
+
+// Identity returns the ID of a certificate identity, derived from the CA,
+// secret, service and names of the certificate. IP addresses are not part
+// of the identity, as they change with the tasks of a service.
+func Identity(request secrets.Request, config driver.CertRequest) string {
+	dnsNames := append([]string{}, config.DNSNames...)
+	sort.Strings(dnsNames)
+
+	var uris []string
+	for _, uri := range config.URIs {
+		uris = append(uris, uri.String())
+	}
+	sort.Strings(uris)
+
+	if config.SPIFFEID != nil {
+		uris = append(uris, config.SPIFFEID.String())
+	}
+
+	fields := []string{
+		config.CAName,
+		request.SecretName,
+		request.ServiceName,
+		config.CommonName,
+		strings.Join(dnsNames, ","),
+		strings.Join(uris, ","),
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\n")))
+
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,172 @@
+package approval_test
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/secrets"
+
+	"docker-secretprovider-pki/approval"
+	"docker-secretprovider-pki/driver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Approval gate", func() {
+	var (
+		dir     string
+		store   *approval.Store
+		gate    *approval.Gate
+		config  driver.CertRequest
+		request secrets.Request
+		id      string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "approval")
+		Expect(err).To(BeNil())
+
+		store = approval.NewStore(dir)
+		gate, err = approval.Parse([]byte(`{"cas": {"payments": {"ttl": "1h"}}}`), store)
+		Expect(err).To(BeNil())
+
+		config = driver.CertRequest{
+			CAName:     "payments",
+			CommonName: "api",
+			DNSNames:   []string{"api.payments.internal"},
+		}
+		request = secrets.Request{SecretName: "api-cert", ServiceName: "payments_api"}
+		id = approval.Identity(request, config)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should record new requests as pending", func() {
+		err := gate.Check(request, config)
+		Expect(err.Error()).To(Equal("request " + id + " is pending approval"))
+
+		records, err := store.List()
+		Expect(err).To(BeNil())
+		Expect(records).To(HaveLen(1))
+		Expect(records[0].ID).To(Equal(id))
+		Expect(records[0].Status).To(Equal(approval.StatusPending))
+		Expect(records[0].Service).To(Equal("payments_api"))
+		Expect(records[0].DNSNames).To(Equal([]string{"api.payments.internal"}))
+
+		Expect(gate.Check(request, config).Error()).To(Equal("request " + id + " is pending approval"))
+	})
+
+	It("should record concurrent requests of an identity once", func() {
+		errs := make([]error, 8)
+
+		wg := sync.WaitGroup{}
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = gate.Check(request, config)
+			}(i)
+		}
+		wg.Wait()
+
+		for _, err := range errs {
+			Expect(err.Error()).To(Equal("request " + id + " is pending approval"))
+		}
+
+		records, err := store.List()
+		Expect(err).To(BeNil())
+		Expect(records).To(HaveLen(1))
+	})
+
+	It("should wait for locks of other stores before deciding", func() {
+		Expect(gate.Check(request, config)).ToNot(Succeed())
+
+		unlock, err := store.Lock(id)
+		Expect(err).To(BeNil())
+
+		decided := make(chan error, 1)
+		go func() {
+			_, err := approval.NewStore(dir).Decide(id, approval.StatusApproved, "")
+			decided <- err
+		}()
+
+		Consistently(decided, 100*time.Millisecond).ShouldNot(Receive())
+
+		unlock()
+		Eventually(decided).Should(Receive(BeNil()))
+		Expect(gate.Check(request, config)).To(Succeed())
+	})
+
+	It("should pass approved requests", func() {
+		Expect(gate.Check(request, config)).ToNot(Succeed())
+
+		_, err := store.Decide(id, approval.StatusApproved, "")
+		Expect(err).To(BeNil())
+
+		Expect(gate.Check(request, config)).To(Succeed())
+		Expect(gate.Check(secrets.Request{SecretName: "api-cert", ServiceName: "payments_api", TaskName: "payments_api.2"}, config)).To(Succeed())
+	})
+
+	It("should require approval of changed identities", func() {
+		Expect(gate.Check(request, config)).ToNot(Succeed())
+		_, err := store.Decide(id, approval.StatusApproved, "")
+		Expect(err).To(BeNil())
+
+		config.DNSNames = []string{"admin.payments.internal"}
+		Expect(gate.Check(request, config)).ToNot(Succeed())
+
+		config.DNSNames = []string{"api.payments.internal"}
+		Expect(gate.Check(secrets.Request{SecretName: "api-cert", ServiceName: "payments_debug"}, config)).ToNot(Succeed())
+	})
+
+	It("should refuse denied requests", func() {
+		Expect(gate.Check(request, config)).ToNot(Succeed())
+
+		_, err := store.Decide(id, approval.StatusDenied, "use the shared certificate")
+		Expect(err).To(BeNil())
+
+		Expect(gate.Check(request, config).Error()).To(Equal("request " + id + " was denied: use the shared certificate"))
+	})
+
+	It("should require approval again after it expires", func() {
+		Expect(gate.Check(request, config)).ToNot(Succeed())
+
+		record, err := store.Decide(id, approval.StatusApproved, "")
+		Expect(err).To(BeNil())
+
+		decided := record.DecidedAt.Add(-2 * time.Hour)
+		record.DecidedAt = &decided
+		Expect(store.Put(record)).To(Succeed())
+
+		Expect(gate.Check(request, config).Error()).To(Equal("approval of request " + id + " expired, the request is pending approval again"))
+
+		record, err = store.Get(id)
+		Expect(err).To(BeNil())
+		Expect(record.Status).To(Equal(approval.StatusPending))
+	})
+
+	It("should pass requests for CAs without approval mode", func() {
+		config.CAName = "internal"
+		Expect(gate.Check(request, config)).To(Succeed())
+
+		records, err := store.List()
+		Expect(err).To(BeNil())
+		Expect(records).To(BeEmpty())
+	})
+
+	It("should reject invalid request IDs", func() {
+		_, err := store.Decide("../policy", approval.StatusApproved, "")
+		Expect(err.Error()).To(Equal("invalid approval request ID: ../policy"))
+	})
+
+	It("should report unknown requests", func() {
+		_, err := store.Decide("0123456789abcdef", approval.StatusApproved, "")
+		Expect(err).To(Equal(approval.ErrNotFound))
+	})
+})
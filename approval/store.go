@@ -0,0 +1,206 @@
+package approval
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Statuses of approval records.
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusDenied   = "denied"
+)
+
+// ErrNotFound is returned for requests without an approval record.
+var ErrNotFound = errors.New("approval request not found")
+
+var validID = regexp.MustCompile(`^[0-9a-f]+$`)
+
+// Record is a certificate identity awaiting or holding a decision.
+type Record struct {
+	ID         string   `json:"id"`
+	CA         string   `json:"ca"`
+	Secret     string   `json:"secret"`
+	Service    string   `json:"service"`
+	CommonName string   `json:"cn"`
+	DNSNames   []string `json:"dns_names,omitempty"`
+	URIs       []string `json:"uris,omitempty"`
+	Status     string   `json:"status"`
+	Reason     string   `json:"reason,omitempty"`
+
+	RequestedAt time.Time  `json:"requested_at"`
+	DecidedAt   *time.Time `json:"decided_at,omitempty"`
+}
+
+// Store keeps approval records as JSON files in a directory. Records are
+// replaced atomically, and updates of a record are serialized with a file
+// lock, as the plugin and the `approval` command run in separate processes.
+type Store struct {
+	dir string
+}
+
+// NewStore creates a store keeping records in the directory.
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+func (s *Store) filename(id string) (string, error) {
+	if !validID.MatchString(id) {
+		return "", errors.Errorf("invalid approval request ID: %s", id)
+	}
+
+	return filepath.Join(s.dir, id+".json"), nil
+}
+
+// Lock acquires an exclusive lock on the record of a request, waiting for
+// other processes holding it. The returned function releases the lock.
+func (s *Store) Lock(id string) (func(), error) {
+	filename, err := s.filename(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "error creating approval directory")
+	}
+
+	file, err := os.OpenFile(strings.TrimSuffix(filename, ".json")+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening approval record lock")
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "error locking approval record")
+	}
+
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
+
+// Get reads the record of a request.
+func (s *Store) Get(id string) (*Record, error) {
+	filename, err := s.filename(id)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, errors.Wrap(err, "error reading approval record")
+	}
+
+	record := &Record{}
+	if err := json.Unmarshal(raw, record); err != nil {
+		return nil, errors.Wrapf(err, "error parsing approval record %s", id)
+	}
+
+	return record, nil
+}
+
+// Put writes the record of a request, replacing the file atomically.
+func (s *Store) Put(record *Record) error {
+	filename, err := s.filename(record.ID)
+	if err != nil {
+		return err
+	}
+
+	raw, err := json.MarshalIndent(record, "", "    ")
+	if err != nil {
+		return errors.Wrap(err, "error encoding approval record")
+	}
+
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return errors.Wrap(err, "error creating approval directory")
+	}
+
+	tmp, err := ioutil.TempFile(s.dir, "."+record.ID)
+	if err != nil {
+		return errors.Wrap(err, "error writing approval record")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "error writing approval record")
+	}
+
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "error writing approval record")
+	}
+
+	return errors.Wrap(os.Rename(tmp.Name(), filename), "error writing approval record")
+}
+
+// List returns all records, oldest requests first.
+func (s *Store) List() ([]*Record, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "error reading approval directory")
+	}
+
+	var records []*Record
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		record, err := s.Get(strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].RequestedAt.Before(records[j].RequestedAt)
+	})
+
+	return records, nil
+}
+
+// Decide approves or denies a request.
+func (s *Store) Decide(id, status, reason string) (*Record, error) {
+	if status != StatusApproved && status != StatusDenied {
+		return nil, errors.Errorf("unknown approval decision: %s", status)
+	}
+
+	unlock, err := s.Lock(id)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	record, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	record.Status = status
+	record.Reason = reason
+	record.DecidedAt = &now
+
+	if err := s.Put(record); err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
@@ -0,0 +1,13 @@
+package approval_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestApproval(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Approval suite")
+}
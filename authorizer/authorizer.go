@@ -0,0 +1,147 @@
+// Package authorizer delegates issuance decisions of CAs to external
+// tooling, called as an HTTP webhook on a local socket or as an executable.
+package authorizer
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/secrets"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"docker-secretprovider-pki/driver"
+	"docker-secretprovider-pki/duration"
+)
+
+// DefaultFile is the name of the authorizer file in plugin's config directory.
+const DefaultFile = "authorizer.json"
+
+// DefaultTimeout limits how long an authorizer can take to decide.
+const DefaultTimeout = 5 * time.Second
+
+// hook calls the external authorizer.
+type hook interface {
+	call(ctx context.Context, request *Request) (*Response, error)
+}
+
+// CAConfig configures the external authorizer of a CA. Either a webhook
+// socket or a command must be configured.
+type CAConfig struct {
+	// Socket is the path of the unix socket the webhook listens on.
+	Socket string `json:"socket"`
+
+	// Path is the HTTP path requests are posted to, `/authorize` by default.
+	Path string `json:"path"`
+
+	// Command is the executable and its arguments called for requests.
+	Command []string `json:"command"`
+
+	Timeout  duration.Duration `json:"timeout"`
+	FailOpen bool              `json:"fail_open"`
+
+	hook hook
+}
+
+// Authorizer holds the external authorizers of CAs. Requests for CAs without
+// an authorizer are issued unchanged.
+type Authorizer struct {
+	CAs map[string]*CAConfig `json:"cas"`
+}
+
+// Load reads the authorizer configuration from a JSON file.
+func Load(filename string) (*Authorizer, error) {
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading authorizer file")
+	}
+
+	return Parse(raw)
+}
+
+// Parse parses and validates a JSON encoded authorizer configuration.
+func Parse(raw []byte) (*Authorizer, error) {
+	a := &Authorizer{}
+	if err := json.Unmarshal(raw, a); err != nil {
+		return nil, errors.Wrap(err, "error parsing authorizer configuration")
+	}
+
+	for name, ca := range a.CAs {
+		if err := ca.compile(); err != nil {
+			return nil, errors.Wrapf(err, "invalid authorizer for CA %s", name)
+		}
+	}
+
+	return a, nil
+}
+
+func (c *CAConfig) compile() error {
+	switch {
+	case c.Socket != "" && len(c.Command) > 0:
+		return errors.New("socket and command can not be used together")
+	case c.Socket != "":
+		path := c.Path
+		if path == "" {
+			path = "/authorize"
+		}
+
+		c.hook = newWebhook(c.Socket, path)
+	case len(c.Command) > 0:
+		c.hook = &command{args: c.Command}
+	default:
+		return errors.New("socket or command is required")
+	}
+
+	if c.Timeout.Duration < 0 {
+		return errors.New("timeout can not be negative")
+	}
+
+	if c.Timeout.Duration == 0 {
+		c.Timeout.Duration = DefaultTimeout
+	}
+
+	return nil
+}
+
+// Authorize asks the authorizer of the requested CA for a decision.
+func (a *Authorizer) Authorize(ctx context.Context, request secrets.Request, config driver.CertRequest) (driver.CertRequest, error) {
+	ca, exists := a.CAs[config.CAName]
+	if !exists {
+		return config, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ca.Timeout.Duration)
+	defer cancel()
+
+	authorized := config
+
+	response, err := ca.hook.call(ctx, newRequest(request, config))
+	if err == nil {
+		err = response.validate()
+	}
+
+	if err == nil && response.Decision == DecisionModify {
+		authorized, err = response.Certificate.apply(config)
+	}
+
+	if err != nil {
+		if ca.FailOpen {
+			zap.S().Warnf("pki: authorizer of CA %s failed, allowing request: %s", config.CAName, err)
+			return config, nil
+		}
+
+		return config, errors.Wrapf(err, "authorizer of CA %s failed", config.CAName)
+	}
+
+	if response.Decision == DecisionDeny {
+		if response.Reason == "" {
+			return config, errors.Errorf("denied by authorizer of CA %s", config.CAName)
+		}
+
+		return config, errors.Errorf("denied by authorizer of CA %s: %s", config.CAName, response.Reason)
+	}
+
+	return authorized, nil
+}
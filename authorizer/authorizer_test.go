@@ -0,0 +1,238 @@
+package authorizer_test
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/secrets"
+
+	"docker-secretprovider-pki/authorizer"
+	"docker-secretprovider-pki/driver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Authorizer", func() {
+	var (
+		config  driver.CertRequest
+		request secrets.Request
+	)
+
+	BeforeEach(func() {
+		config = driver.CertRequest{
+			CAName:     "payments",
+			CommonName: "api",
+			DNSNames:   []string{"api.payments.internal"},
+			Usage:      []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			Lifetime:   24 * time.Hour,
+			KeyType:    driver.KeyTypeECDSA,
+			KeySize:    256,
+		}
+
+		request = secrets.Request{
+			SecretName:  "api-cert",
+			ServiceName: "payments_api",
+			TaskName:    "payments_api.1",
+		}
+	})
+
+	parse := func(raw string) *authorizer.Authorizer {
+		a, err := authorizer.Parse([]byte(raw))
+		Expect(err).To(BeNil())
+		return a
+	}
+
+	Context("Webhook", func() {
+		var (
+			dir      string
+			socket   string
+			server   *http.Server
+			received *authorizer.Request
+			respond  func(w http.ResponseWriter)
+
+			// handlers tracks requests in flight, e.g. of timed out calls,
+			// which must finish before the next spec.
+			handlers sync.WaitGroup
+		)
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "authorizer")
+			Expect(err).To(BeNil())
+
+			socket = filepath.Join(dir, "authz.sock")
+			listener, err := net.Listen("unix", socket)
+			Expect(err).To(BeNil())
+
+			received = nil
+			server = &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handlers.Add(1)
+				defer handlers.Done()
+				defer GinkgoRecover()
+				Expect(r.URL.Path).To(Equal("/authorize"))
+
+				received = &authorizer.Request{}
+				Expect(json.NewDecoder(r.Body).Decode(received)).To(Succeed())
+
+				respond(w)
+			})}
+			go server.Serve(listener)
+		})
+
+		AfterEach(func() {
+			server.Close()
+			handlers.Wait()
+			os.RemoveAll(dir)
+		})
+
+		webhook := func() *authorizer.Authorizer {
+			return parse(fmt.Sprintf(`{"cas": {"payments": {"socket": %q, "timeout": "200ms"}}}`, socket))
+		}
+
+		It("should send the request and its metadata", func() {
+			respond = func(w http.ResponseWriter) {
+				fmt.Fprint(w, `{"decision": "allow"}`)
+			}
+
+			authorized, err := webhook().Authorize(context.Background(), request, config)
+			Expect(err).To(BeNil())
+			Expect(authorized).To(Equal(config))
+
+			Expect(received.Request.ServiceName).To(Equal("payments_api"))
+			Expect(received.Request.TaskName).To(Equal("payments_api.1"))
+			Expect(received.Certificate.CA).To(Equal("payments"))
+			Expect(received.Certificate.DNSNames).To(Equal([]string{"api.payments.internal"}))
+			Expect(received.Certificate.Usage).To(Equal([]string{"server"}))
+			Expect(received.Certificate.Lifetime).To(Equal("24h0m0s"))
+			Expect(received.Certificate.KeyType).To(Equal("ecdsa"))
+		})
+
+		It("should deny with the authorizer's reason", func() {
+			respond = func(w http.ResponseWriter) {
+				fmt.Fprint(w, `{"decision": "deny", "reason": "change freeze"}`)
+			}
+
+			_, err := webhook().Authorize(context.Background(), request, config)
+			Expect(err.Error()).To(Equal("denied by authorizer of CA payments: change freeze"))
+		})
+
+		It("should issue the modified request", func() {
+			respond = func(w http.ResponseWriter) {
+				fmt.Fprint(w, `{"decision": "modify", "certificate": {
+					"cn": "api",
+					"dns_names": ["api.payments.internal"],
+					"ip_addrs": ["10.0.0.1"],
+					"usage": ["server", "client"],
+					"lifetime": "1h"
+				}}`)
+			}
+
+			authorized, err := webhook().Authorize(context.Background(), request, config)
+			Expect(err).To(BeNil())
+			Expect(authorized.IPAddrs).To(HaveLen(1))
+			Expect(authorized.IPAddrs[0].String()).To(Equal("10.0.0.1"))
+			Expect(authorized.Usage).To(Equal([]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}))
+			Expect(authorized.Lifetime).To(Equal(time.Hour))
+			Expect(authorized.KeyType).To(Equal("ecdsa"))
+		})
+
+		It("should not allow changing the CA", func() {
+			respond = func(w http.ResponseWriter) {
+				fmt.Fprint(w, `{"decision": "modify", "certificate": {"ca": "other", "cn": "api", "usage": ["server"]}}`)
+			}
+
+			_, err := webhook().Authorize(context.Background(), request, config)
+			Expect(err.Error()).To(Equal("authorizer of CA payments failed: modified request can not change the CA to other"))
+		})
+
+		It("should fail closed on errors", func() {
+			respond = func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+
+			_, err := webhook().Authorize(context.Background(), request, config)
+			Expect(err.Error()).To(Equal("authorizer of CA payments failed: authorizer webhook returned status: 500 Internal Server Error"))
+		})
+
+		It("should refuse oversized responses", func() {
+			respond = func(w http.ResponseWriter) {
+				fmt.Fprintf(w, `{"decision": "allow", "reason": %q}`, strings.Repeat("a", 1<<20))
+			}
+
+			_, err := webhook().Authorize(context.Background(), request, config)
+			Expect(err.Error()).To(Equal("authorizer of CA payments failed: authorizer webhook response exceeds 1048576 bytes"))
+		})
+
+		It("should fail closed on timeouts", func() {
+			respond = func(w http.ResponseWriter) {
+				time.Sleep(time.Second)
+			}
+
+			_, err := webhook().Authorize(context.Background(), request, config)
+			Expect(err.Error()).To(HavePrefix("authorizer of CA payments failed: error calling authorizer webhook"))
+		})
+
+		It("should fail open when configured", func() {
+			respond = func(w http.ResponseWriter) {
+				fmt.Fprint(w, `{"decision": "perhaps"}`)
+			}
+
+			a := parse(fmt.Sprintf(`{"cas": {"payments": {"socket": %q, "fail_open": true}}}`, socket))
+
+			authorized, err := a.Authorize(context.Background(), request, config)
+			Expect(err).To(BeNil())
+			Expect(authorized).To(Equal(config))
+		})
+	})
+
+	Context("Command", func() {
+		It("should read the decision from the command's output", func() {
+			a := parse(`{"cas": {"payments": {"command": ["sh", "-c", "grep -q payments_api && echo '{\"decision\": \"deny\", \"reason\": \"not yet\"}'"]}}}`)
+
+			_, err := a.Authorize(context.Background(), request, config)
+			Expect(err.Error()).To(Equal("denied by authorizer of CA payments: not yet"))
+		})
+
+		It("should report the command's errors", func() {
+			a := parse(`{"cas": {"payments": {"command": ["sh", "-c", "echo broken >&2; exit 1"]}}}`)
+
+			_, err := a.Authorize(context.Background(), request, config)
+			Expect(err.Error()).To(Equal("authorizer of CA payments failed: error running authorizer command: broken: exit status 1"))
+		})
+
+		It("should fail on timeouts", func() {
+			a := parse(`{"cas": {"payments": {"command": ["sleep", "5"], "timeout": "100ms"}}}`)
+
+			_, err := a.Authorize(context.Background(), request, config)
+			Expect(err.Error()).To(Equal("authorizer of CA payments failed: error running authorizer command: context deadline exceeded"))
+		})
+	})
+
+	When("CA has no authorizer", func() {
+		It("should pass the request unchanged", func() {
+			a := parse(`{"cas": {"other": {"command": ["false"]}}}`)
+
+			authorized, err := a.Authorize(context.Background(), request, config)
+			Expect(err).To(BeNil())
+			Expect(authorized).To(Equal(config))
+		})
+	})
+
+	When("Configuration is not valid", func() {
+		It("should require a socket or command", func() {
+			_, err := authorizer.Parse([]byte(`{"cas": {"payments": {"timeout": "1s"}}}`))
+			Expect(err.Error()).To(Equal("invalid authorizer for CA payments: socket or command is required"))
+		})
+	})
+})
@@ -0,0 +1,118 @@
+package authorizer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxResponseSize limits the size of authorizer responses.
+const maxResponseSize = 1 << 20
+
+// webhook posts requests to an HTTP server listening on a unix socket.
+type webhook struct {
+	client *http.Client
+	path   string
+}
+
+func newWebhook(socket, path string) *webhook {
+	dialer := &net.Dialer{}
+
+	return &webhook{
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+		path: path,
+	}
+}
+
+func (w *webhook) call(ctx context.Context, request *Request) (*Response, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, errors.Wrap(err, "error encoding authorization request")
+	}
+
+	// The host is ignored as the connection is made to the socket.
+	req, err := http.NewRequest(http.MethodPost, "http://authorizer"+w.path, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating authorization request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "error calling authorizer webhook")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("authorizer webhook returned status: %s", resp.Status)
+	}
+
+	raw, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading authorizer webhook response")
+	}
+
+	if len(raw) > maxResponseSize {
+		return nil, errors.Errorf("authorizer webhook response exceeds %d bytes", maxResponseSize)
+	}
+
+	return parseResponse(raw)
+}
+
+// command runs an executable with the request on its standard input,
+// reading the decision from its standard output.
+type command struct {
+	args []string
+}
+
+func (c *command) call(ctx context.Context, request *Request) (*Response, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, errors.Wrap(err, "error encoding authorization request")
+	}
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+
+	cmd := exec.CommandContext(ctx, c.args[0], c.args[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, errors.Wrap(ctx.Err(), "error running authorizer command")
+		}
+
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.Wrapf(err, "error running authorizer command: %s", msg)
+		}
+
+		return nil, errors.Wrap(err, "error running authorizer command")
+	}
+
+	return parseResponse(stdout.Bytes())
+}
+
+func parseResponse(raw []byte) (*Response, error) {
+	response := &Response{}
+	if err := json.Unmarshal(raw, response); err != nil {
+		return nil, errors.Wrap(err, "error parsing authorizer response")
+	}
+
+	return response, nil
+}
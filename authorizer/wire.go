@@ -0,0 +1,153 @@
+package authorizer
+
+import (
+	"crypto/x509"
+	"net"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/secrets"
+	"github.com/pkg/errors"
+
+	"docker-secretprovider-pki/driver"
+)
+
+// Decisions returned by authorizers.
+const (
+	DecisionAllow  = "allow"
+	DecisionDeny   = "deny"
+	DecisionModify = "modify"
+)
+
+// Request is sent to the authorizer as JSON.
+type Request struct {
+	Request     secrets.Request `json:"request"`
+	Certificate Certificate     `json:"certificate"`
+}
+
+// Certificate describes the requested certificate.
+type Certificate struct {
+	CA         string   `json:"ca"`
+	CommonName string   `json:"cn"`
+	DNSNames   []string `json:"dns_names"`
+	IPAddrs    []string `json:"ip_addrs"`
+	URIs       []string `json:"uris"`
+	SPIFFEID   string   `json:"spiffe_id,omitempty"`
+	Usage      []string `json:"usage"`
+	Lifetime   string   `json:"lifetime"`
+	KeyType    string   `json:"key_type"`
+	KeySize    int      `json:"key_size,omitempty"`
+	Group      string   `json:"group,omitempty"`
+}
+
+// Response is the authorizer's JSON encoded decision. Modified requests
+// return the certificate to issue, of which the common name, DNS names,
+// IP addresses, usage and lifetime are taken over.
+type Response struct {
+	Decision    string       `json:"decision"`
+	Reason      string       `json:"reason"`
+	Certificate *Certificate `json:"certificate"`
+}
+
+// usages maps usage names to extended key usages.
+var usages = map[string]x509.ExtKeyUsage{
+	"server": x509.ExtKeyUsageServerAuth,
+	"client": x509.ExtKeyUsageClientAuth,
+}
+
+func newRequest(request secrets.Request, config driver.CertRequest) *Request {
+	cert := Certificate{
+		CA:         config.CAName,
+		CommonName: config.CommonName,
+		DNSNames:   config.DNSNames,
+		Lifetime:   config.Lifetime.String(),
+		KeyType:    config.KeyType,
+		KeySize:    config.KeySize,
+		Group:      config.Group,
+	}
+
+	for _, addr := range config.IPAddrs {
+		cert.IPAddrs = append(cert.IPAddrs, addr.String())
+	}
+
+	for _, uri := range config.URIs {
+		cert.URIs = append(cert.URIs, uri.String())
+	}
+
+	if config.SPIFFEID != nil {
+		cert.SPIFFEID = config.SPIFFEID.String()
+	}
+
+	for _, usage := range config.Usage {
+		for name, u := range usages {
+			if u == usage {
+				cert.Usage = append(cert.Usage, name)
+			}
+		}
+	}
+
+	return &Request{Request: request, Certificate: cert}
+}
+
+func (r *Response) validate() error {
+	switch r.Decision {
+	case DecisionAllow, DecisionDeny:
+		return nil
+	case DecisionModify:
+		if r.Certificate == nil {
+			return errors.New("modified request has no certificate")
+		}
+		return nil
+	default:
+		return errors.Errorf("unknown decision: %q", r.Decision)
+	}
+}
+
+// apply takes over the modifications of the certificate to the request.
+func (c *Certificate) apply(config driver.CertRequest) (driver.CertRequest, error) {
+	if c.CA != "" && c.CA != config.CAName {
+		return config, errors.Errorf("modified request can not change the CA to %s", c.CA)
+	}
+
+	if c.CommonName == "" {
+		return config, errors.New("modified request has no common name")
+	}
+
+	modified := config
+	modified.CommonName = c.CommonName
+	modified.DNSNames = c.DNSNames
+	modified.IPAddrs = nil
+	modified.Usage = nil
+
+	for _, addr := range c.IPAddrs {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return config, errors.Errorf("modified request has an invalid IP address: %s", addr)
+		}
+
+		modified.IPAddrs = append(modified.IPAddrs, ip)
+	}
+
+	for _, name := range c.Usage {
+		usage, exists := usages[name]
+		if !exists {
+			return config, errors.Errorf("modified request has an unknown usage: %s", name)
+		}
+
+		modified.Usage = append(modified.Usage, usage)
+	}
+
+	if len(modified.Usage) == 0 {
+		return config, errors.New("modified request has no usage")
+	}
+
+	if c.Lifetime != "" {
+		lifetime, err := time.ParseDuration(c.Lifetime)
+		if err != nil || lifetime <= 0 {
+			return config, errors.Errorf("modified request has an invalid lifetime: %s", c.Lifetime)
+		}
+
+		modified.Lifetime = lifetime
+	}
+
+	return modified, nil
+}
@@ -0,0 +1,216 @@
+package backend
+
+import (
+	"context"
+	"crypto/x509"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"docker-secretprovider-pki/driver"
+)
+
+// Caching backend defaults.
+const (
+	DefaultCacheTTL         = 5 * time.Minute
+	DefaultCacheNotFoundTTL = 30 * time.Second
+)
+
+// CacheConfig configures the caching backend.
+type CacheConfig struct {
+	// TTL is how long loaded CAs are served from the cache.
+	TTL time.Duration
+
+	// NotFoundTTL is how long CAs which don't exist in the backend are
+	// remembered as such.
+	NotFoundTTL time.Duration
+}
+
+// NewCachingBackend creates a backend caching the CAs loaded from the
+// backend. Unset TTLs fall back to the defaults.
+func NewCachingBackend(b driver.CABackend, config CacheConfig) (*CachingBackend, error) {
+	if config.TTL == 0 {
+		config.TTL = DefaultCacheTTL
+	}
+
+	if config.NotFoundTTL == 0 {
+		config.NotFoundTTL = DefaultCacheNotFoundTTL
+	}
+
+	if config.TTL < 0 || config.NotFoundTTL < 0 {
+		return nil, errors.New("cache TTLs can not be negative")
+	}
+
+	return &CachingBackend{
+		backend: b,
+		config:  config,
+		entries: map[cacheKey]*cacheEntry{},
+	}, nil
+}
+
+// CachingBackend is PKI backend which caches CAs loaded from another
+// backend, sparing it a fetch on every certificate request. Concurrent loads
+// of a CA share a single fetch, and CAs which don't exist are cached too.
+// Errors other than the CA not existing aren't cached.
+type CachingBackend struct {
+	backend driver.CABackend
+	config  CacheConfig
+
+	mu      sync.Mutex
+	entries map[cacheKey]*cacheEntry
+}
+
+// cacheKey identifies a cached CA, or its certificate chain loaded without
+// the private key.
+type cacheKey struct {
+	ca           string
+	certificates bool
+}
+
+// cacheEntry holds the result of loading a CA. It's being loaded until done
+// is closed.
+type cacheEntry struct {
+	done    chan struct{}
+	ca      *driver.CA
+	chain   []*x509.Certificate
+	err     error
+	expires time.Time
+}
+
+// Load returns the CA from the cache, loading it from the backend when it's
+// not cached or has expired.
+func (c *CachingBackend) Load(ctx context.Context, ca string) (*driver.CA, error) {
+	e, err := c.load(ctx, cacheKey{ca: ca}, func(ctx context.Context, e *cacheEntry) (err error) {
+		e.ca, err = c.backend.Load(ctx, ca)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return e.ca, nil
+}
+
+// LoadCertificates returns the CA certificate chain from the cache, loading
+// it from the backend when it's not cached or has expired.
+func (c *CachingBackend) LoadCertificates(ctx context.Context, ca string) ([]*x509.Certificate, error) {
+	trust, ok := c.backend.(driver.TrustBackend)
+	if !ok {
+		loaded, err := c.Load(ctx, ca)
+		if err != nil {
+			return nil, err
+		}
+
+		return loaded.Chain, nil
+	}
+
+	e, err := c.load(ctx, cacheKey{ca: ca, certificates: true}, func(ctx context.Context, e *cacheEntry) (err error) {
+		e.chain, err = trust.LoadCertificates(ctx, ca)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return e.chain, nil
+}
+
+// Invalidate drops the CA from the cache, e.g. after it was rotated, so that
+// it's loaded from the backend again on next use.
+func (c *CachingBackend) Invalidate(ca string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, cacheKey{ca: ca})
+	delete(c.entries, cacheKey{ca: ca, certificates: true})
+}
+
+// InvalidateAll drops all CAs from the cache.
+func (c *CachingBackend) InvalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = map[cacheKey]*cacheEntry{}
+}
+
+// Len returns the number of CAs and certificate chains in the cache.
+func (c *CachingBackend) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries)
+}
+
+// sweep drops expired entries, so that CAs requested once, e.g. misspelled
+// CA names, don't stay in the cache. The caller holds the lock.
+func (c *CachingBackend) sweep() {
+	now := time.Now()
+	for key, e := range c.entries {
+		select {
+		case <-e.done:
+			if !now.Before(e.expires) {
+				delete(c.entries, key)
+			}
+		default:
+		}
+	}
+}
+
+// load returns the cached entry, fetching it when it's missing or expired.
+// Callers of an entry being fetched wait for the fetch to complete.
+func (c *CachingBackend) load(ctx context.Context, key cacheKey, fetch func(context.Context, *cacheEntry) error) (*cacheEntry, error) {
+	c.mu.Lock()
+
+	e, exists := c.entries[key]
+	if exists {
+		select {
+		case <-e.done:
+			if time.Now().Before(e.expires) {
+				c.mu.Unlock()
+				return e, e.err
+			}
+			exists = false
+		default:
+		}
+	}
+
+	if exists {
+		c.mu.Unlock()
+
+		select {
+		case <-e.done:
+			return e, e.err
+		case <-ctx.Done():
+			return nil, errors.Wrap(ctx.Err(), "error waiting for CA to load")
+		}
+	}
+
+	c.sweep()
+
+	e = &cacheEntry{done: make(chan struct{})}
+	c.entries[key] = e
+	c.mu.Unlock()
+
+	err := fetch(ctx, e)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e.err = err
+	if err == nil {
+		e.expires = time.Now().Add(c.config.TTL)
+	} else if driver.IsNotFound(err) {
+		e.expires = time.Now().Add(c.config.NotFoundTTL)
+	}
+
+	// Failed fetches aren't cached, so that the next caller retries. Entries
+	// invalidated while being fetched are already gone from the cache.
+	if e.expires.IsZero() && c.entries[key] == e {
+		delete(c.entries, key)
+	}
+
+	close(e.done)
+
+	return e, err
+}
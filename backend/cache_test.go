@@ -0,0 +1,249 @@
+package backend_test
+
+import (
+	"context"
+	"crypto/x509"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"docker-secretprovider-pki/backend"
+	"docker-secretprovider-pki/driver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// countingBackend counts loads of CAs, which are held until release is
+// closed.
+type countingBackend struct {
+	loads   int32
+	release chan struct{}
+	err     error
+}
+
+func (b *countingBackend) Load(ctx context.Context, ca string) (*driver.CA, error) {
+	atomic.AddInt32(&b.loads, 1)
+	<-b.release
+
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	return &driver.CA{Chain: []*x509.Certificate{{}}}, nil
+}
+
+var _ = Describe("Caching backend", func() {
+	var (
+		b      *countingBackend
+		config backend.CacheConfig
+	)
+
+	BeforeEach(func() {
+		b = &countingBackend{release: make(chan struct{})}
+		close(b.release)
+
+		config = backend.CacheConfig{TTL: time.Hour, NotFoundTTL: time.Hour}
+	})
+
+	newCache := func() *backend.CachingBackend {
+		c, err := backend.NewCachingBackend(b, config)
+		Expect(err).To(BeNil())
+		return c
+	}
+
+	It("should serve CAs from the cache", func() {
+		c := newCache()
+
+		first, err := c.Load(context.Background(), "test")
+		Expect(err).To(BeNil())
+
+		again, err := c.Load(context.Background(), "test")
+		Expect(err).To(BeNil())
+		Expect(again).To(BeIdenticalTo(first))
+
+		chain, err := c.LoadCertificates(context.Background(), "test")
+		Expect(err).To(BeNil())
+		Expect(chain).To(Equal(first.Chain))
+
+		Expect(b.loads).To(Equal(int32(1)))
+	})
+
+	It("should cache CAs per name", func() {
+		c := newCache()
+
+		_, err := c.Load(context.Background(), "test")
+		Expect(err).To(BeNil())
+
+		_, err = c.Load(context.Background(), "other")
+		Expect(err).To(BeNil())
+
+		Expect(b.loads).To(Equal(int32(2)))
+	})
+
+	It("should load expired CAs again", func() {
+		config.TTL = 10 * time.Millisecond
+		c := newCache()
+
+		_, err := c.Load(context.Background(), "test")
+		Expect(err).To(BeNil())
+
+		time.Sleep(20 * time.Millisecond)
+
+		_, err = c.Load(context.Background(), "test")
+		Expect(err).To(BeNil())
+		Expect(b.loads).To(Equal(int32(2)))
+	})
+
+	It("should share concurrent loads", func() {
+		b.release = make(chan struct{})
+		c := newCache()
+
+		wg := sync.WaitGroup{}
+		cas := make([]*driver.CA, 10)
+		for i := range cas {
+			wg.Add(1)
+			go func(i int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				var err error
+				cas[i], err = c.Load(context.Background(), "test")
+				Expect(err).To(BeNil())
+			}(i)
+		}
+
+		Eventually(func() int32 { return atomic.LoadInt32(&b.loads) }).Should(Equal(int32(1)))
+		close(b.release)
+		wg.Wait()
+
+		Expect(b.loads).To(Equal(int32(1)))
+		for _, ca := range cas {
+			Expect(ca).To(BeIdenticalTo(cas[0]))
+		}
+	})
+
+	It("should stop waiting for a load when the request is cancelled", func() {
+		b.release = make(chan struct{})
+		defer close(b.release)
+		c := newCache()
+
+		go c.Load(context.Background(), "test")
+		Eventually(func() int32 { return atomic.LoadInt32(&b.loads) }).Should(Equal(int32(1)))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := c.Load(ctx, "test")
+		Expect(err).To(MatchError("error waiting for CA to load: context canceled"))
+	})
+
+	It("should cache CAs which don't exist", func() {
+		b.err = driver.NotFound(errors.New("CA not found: test"))
+		c := newCache()
+
+		for i := 0; i < 2; i++ {
+			_, err := c.Load(context.Background(), "test")
+			Expect(err).To(MatchError("CA not found: test"))
+			Expect(driver.IsNotFound(err)).To(BeTrue())
+		}
+
+		Expect(b.loads).To(Equal(int32(1)))
+	})
+
+	It("should expire CAs which don't exist with their own TTL", func() {
+		b.err = driver.NotFound(errors.New("CA not found: test"))
+		config.NotFoundTTL = 10 * time.Millisecond
+		c := newCache()
+
+		_, err := c.Load(context.Background(), "test")
+		Expect(err).ToNot(BeNil())
+
+		time.Sleep(20 * time.Millisecond)
+		b.err = nil
+
+		_, err = c.Load(context.Background(), "test")
+		Expect(err).To(BeNil())
+		Expect(b.loads).To(Equal(int32(2)))
+	})
+
+	It("should drop expired CAs when loading others", func() {
+		b.err = driver.NotFound(errors.New("CA not found"))
+		config.NotFoundTTL = 10 * time.Millisecond
+		c := newCache()
+
+		for _, ca := range []string{"tset", "tets", "etst"} {
+			_, err := c.Load(context.Background(), ca)
+			Expect(err).ToNot(BeNil())
+		}
+		Expect(c.Len()).To(Equal(3))
+
+		time.Sleep(20 * time.Millisecond)
+		b.err = nil
+
+		_, err := c.Load(context.Background(), "test")
+		Expect(err).To(BeNil())
+		Expect(c.Len()).To(Equal(1))
+	})
+
+	It("should not cache other errors", func() {
+		b.err = errors.New("backend unavailable")
+		c := newCache()
+
+		_, err := c.Load(context.Background(), "test")
+		Expect(err).To(MatchError("backend unavailable"))
+
+		b.err = nil
+
+		_, err = c.Load(context.Background(), "test")
+		Expect(err).To(BeNil())
+		Expect(b.loads).To(Equal(int32(2)))
+	})
+
+	It("should load invalidated CAs again", func() {
+		c := newCache()
+
+		first, err := c.Load(context.Background(), "test")
+		Expect(err).To(BeNil())
+
+		_, err = c.Load(context.Background(), "other")
+		Expect(err).To(BeNil())
+
+		c.Invalidate("test")
+
+		again, err := c.Load(context.Background(), "test")
+		Expect(err).To(BeNil())
+		Expect(again).ToNot(BeIdenticalTo(first))
+
+		_, err = c.Load(context.Background(), "other")
+		Expect(err).To(BeNil())
+		Expect(b.loads).To(Equal(int32(3)))
+
+		c.InvalidateAll()
+
+		_, err = c.Load(context.Background(), "other")
+		Expect(err).To(BeNil())
+		Expect(b.loads).To(Equal(int32(4)))
+	})
+
+	It("should cache certificate chains of trust backends separately", func() {
+		test := &backend.TestBackend{}
+		c, err := backend.NewCachingBackend(test, config)
+		Expect(err).To(BeNil())
+
+		chain, err := c.LoadCertificates(context.Background(), "test")
+		Expect(err).To(BeNil())
+
+		again, err := c.LoadCertificates(context.Background(), "test")
+		Expect(err).To(BeNil())
+		Expect(again).To(Equal(chain))
+	})
+
+	It("should reject negative TTLs", func() {
+		config.TTL = -time.Second
+		_, err := backend.NewCachingBackend(b, config)
+		Expect(err).To(MatchError("cache TTLs can not be negative"))
+	})
+})
@@ -0,0 +1,50 @@
+package backend
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"docker-secretprovider-pki/driver"
+	"docker-secretprovider-pki/duration"
+	"docker-secretprovider-pki/policy"
+)
+
+// CAConfigFile is the name of the optional file holding the settings of a
+// CA next to its certificate.
+const CAConfigFile = "config.json"
+
+// caConfig is the JSON encoding of the settings of a CA.
+type caConfig struct {
+	TrustDomain string            `json:"trust_domain"`
+	Lifetime    duration.Duration `json:"lifetime"`
+	KeyType     string            `json:"key_type"`
+	KeySize     int               `json:"key_size"`
+	Policy      json.RawMessage   `json:"policy"`
+}
+
+// parseCAConfig parses JSON encoded settings of a CA.
+func parseCAConfig(raw []byte) (driver.CAConfig, error) {
+	c := caConfig{}
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return driver.CAConfig{}, errors.Wrap(err, "error parsing CA configuration")
+	}
+
+	config := driver.CAConfig{
+		TrustDomain: c.TrustDomain,
+		Lifetime:    c.Lifetime.Duration,
+		KeyType:     c.KeyType,
+		KeySize:     c.KeySize,
+	}
+
+	if len(c.Policy) > 0 {
+		p, err := policy.ParseCAPolicy(c.Policy)
+		if err != nil {
+			return driver.CAConfig{}, err
+		}
+
+		config.Policy = p
+	}
+
+	return config, nil
+}
@@ -0,0 +1,86 @@
+package backend
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+
+	"github.com/pkg/errors"
+
+	"docker-secretprovider-pki/pkcs8"
+)
+
+// NewEncryptedFileBackend creates a file backend for CAs whose private keys
+// are encrypted with the passphrase. Keys are decrypted when loaded and held
+// in memory only.
+func NewEncryptedFileBackend(dir string, passphrase []byte) (*FileBackend, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("passphrase of CA private keys is empty")
+	}
+
+	b, err := NewFileBackend(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	b.passphrase = passphrase
+
+	return b, nil
+}
+
+// decryptPrivateKey decrypts an encrypted PKCS#8 or legacy encrypted PEM
+// private key. Unencrypted keys are refused.
+func decryptPrivateKey(block *pem.Block, passphrase []byte) (*pem.Block, error) {
+	if block.Type == pkcs8.PEMType {
+		der, err := pkcs8.Decrypt(block.Bytes, passphrase)
+		if err != nil {
+			return nil, errors.Wrap(err, "error decrypting CA private key")
+		}
+
+		return &pem.Block{Type: "PRIVATE KEY", Bytes: der}, nil
+	}
+
+	// Legacy encrypted PEM is insecure by design, but still produced by
+	// `openssl genrsa -aes256` and alike.
+	if x509.IsEncryptedPEMBlock(block) {
+		der, err := x509.DecryptPEMBlock(block, passphrase)
+		if err == x509.IncorrectPasswordError {
+			return nil, errors.New("error decrypting CA private key: incorrect passphrase")
+		} else if err != nil {
+			return nil, errors.Wrap(err, "error decrypting CA private key")
+		}
+
+		return &pem.Block{Type: block.Type, Bytes: der}, nil
+	}
+
+	return nil, errors.Errorf("CA private key is not encrypted: %s", block.Type)
+}
+
+// EncryptPrivateKey converts a PEM encoded private key into an encrypted
+// PKCS#8 private key loadable by the encrypted file backend.
+func EncryptPrivateKey(data []byte, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("passphrase can not be empty")
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("got empty decode result for private key")
+	}
+
+	key, err := parsePrivateKey(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing private key")
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling private key")
+	}
+
+	encrypted, err := pkcs8.Encrypt(der, passphrase)
+	if err != nil {
+		return nil, errors.Wrap(err, "error encrypting private key")
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: pkcs8.PEMType, Bytes: encrypted}), nil
+}
@@ -0,0 +1,116 @@
+package backend_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"docker-secretprovider-pki/backend"
+	"docker-secretprovider-pki/pkcs8"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Encrypted file backend", func() {
+	var (
+		dir        string
+		passphrase = []byte("correct horse battery staple")
+	)
+
+	// encryptKey replaces the CA's private key with the encrypted key.
+	encryptKey := func(name string, encrypt func(der []byte) *pem.Block) {
+		keyFile := filepath.Join(dir, name, backend.KeyFile)
+
+		raw, err := ioutil.ReadFile(keyFile)
+		Expect(err).To(BeNil())
+
+		block, _ := pem.Decode(raw)
+		Expect(ioutil.WriteFile(keyFile, pem.EncodeToMemory(encrypt(block.Bytes)), 0600)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "cas")
+		Expect(err).To(BeNil())
+
+		writeCA(dir, "internal", "Internal CA", 0600)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should load CAs with encrypted PKCS#8 keys", func() {
+		encryptKey("internal", func(der []byte) *pem.Block {
+			encrypted, err := pkcs8.Encrypt(der, passphrase)
+			Expect(err).To(BeNil())
+			return &pem.Block{Type: pkcs8.PEMType, Bytes: encrypted}
+		})
+
+		ca, err := backend.NewEncryptedFileBackend(dir, passphrase)
+		Expect(err).To(BeNil())
+
+		cert, err := ca.Load(context.Background(), "internal")
+		Expect(err).To(BeNil())
+		Expect(cert.Signer).ToNot(BeNil())
+	})
+
+	It("should load CAs with legacy encrypted PEM keys", func() {
+		encryptKey("internal", func(der []byte) *pem.Block {
+			block, err := x509.EncryptPEMBlock(rand.Reader, "PRIVATE KEY", der, passphrase, x509.PEMCipherAES256)
+			Expect(err).To(BeNil())
+			return block
+		})
+
+		ca, err := backend.NewEncryptedFileBackend(dir, passphrase)
+		Expect(err).To(BeNil())
+
+		cert, err := ca.Load(context.Background(), "internal")
+		Expect(err).To(BeNil())
+		Expect(cert.Signer).ToNot(BeNil())
+	})
+
+	It("should load keys encrypted with EncryptPrivateKey", func() {
+		keyFile := filepath.Join(dir, "internal", backend.KeyFile)
+
+		raw, err := ioutil.ReadFile(keyFile)
+		Expect(err).To(BeNil())
+
+		encrypted, err := backend.EncryptPrivateKey(raw, passphrase)
+		Expect(err).To(BeNil())
+		Expect(ioutil.WriteFile(keyFile, encrypted, 0600)).To(Succeed())
+
+		ca, err := backend.NewEncryptedFileBackend(dir, passphrase)
+		Expect(err).To(BeNil())
+
+		_, err = ca.Load(context.Background(), "internal")
+		Expect(err).To(BeNil())
+	})
+
+	It("should fail with incorrect passphrase", func() {
+		encryptKey("internal", func(der []byte) *pem.Block {
+			encrypted, err := pkcs8.Encrypt(der, passphrase)
+			Expect(err).To(BeNil())
+			return &pem.Block{Type: pkcs8.PEMType, Bytes: encrypted}
+		})
+
+		ca, err := backend.NewEncryptedFileBackend(dir, []byte("incorrect"))
+		Expect(err).To(BeNil())
+
+		_, err = ca.Load(context.Background(), "internal")
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("should refuse unencrypted keys", func() {
+		ca, err := backend.NewEncryptedFileBackend(dir, passphrase)
+		Expect(err).To(BeNil())
+
+		_, err = ca.Load(context.Background(), "internal")
+		Expect(err.Error()).To(Equal("CA private key is not encrypted: PRIVATE KEY"))
+	})
+})
@@ -0,0 +1,228 @@
+package backend
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"docker-secretprovider-pki/driver"
+)
+
+// DefaultFileDir is the directory the file backend loads CAs from.
+const DefaultFileDir = "/etc/docker/pki/cas"
+
+// Names of the files holding a CA in its directory.
+const (
+	CertFile = "cert.pem"
+	KeyFile  = "key.pem"
+)
+
+// NewFileBackend creates a backend loading CAs from the directory.
+func NewFileBackend(dir string) (*FileBackend, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading CA directory")
+	}
+
+	if !info.IsDir() {
+		return nil, errors.Errorf("CA directory is not a directory: %s", dir)
+	}
+
+	return &FileBackend{
+		dir: dir,
+		cas: map[string]*fileCA{},
+	}, nil
+}
+
+// FileBackend is PKI backend which loads CA `<name>` from the certificate
+// chain in `<dir>/<name>/cert.pem` and private key in `<dir>/<name>/key.pem`.
+// Settings of the CA are read from optional `<dir>/<name>/config.json`. CAs
+// are reloaded when the files change.
+//
+// Changes are detected by comparing modification time and size of the files
+// on every load rather than by watching them. Stat calls are cheap next to
+// issuing a certificate, and unlike inotify watches they work on the bind
+// mounted config directory, across directory replacements and with any
+// number of CAs. A key pair caught in the middle of a rotation doesn't match
+// and fails to load, so it's not cached, and the next load retries.
+type FileBackend struct {
+	dir        string
+	passphrase []byte
+
+	mu  sync.Mutex
+	cas map[string]*fileCA
+}
+
+// fileCA is a loaded CA, along with the state of its files when loaded.
+type fileCA struct {
+	ca          *driver.CA
+	certState   fileState
+	keyState    fileState
+	configState fileState
+}
+
+// fileState identifies a version of a file.
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+func stateOf(info os.FileInfo) fileState {
+	return fileState{modTime: info.ModTime(), size: info.Size()}
+}
+
+// Load returns the CA, reloading it when its files have changed.
+func (b *FileBackend) Load(ctx context.Context, ca string) (*driver.CA, error) {
+	certFile, keyFile, err := b.files(ca)
+	if err != nil {
+		return nil, err
+	}
+
+	configFile := filepath.Join(filepath.Dir(certFile), CAConfigFile)
+
+	certInfo, err := os.Stat(certFile)
+	if err != nil {
+		return nil, certificateError(err)
+	}
+
+	keyInfo, err := os.Stat(keyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading CA private key")
+	}
+
+	if err := checkKeyPermissions(keyFile, keyInfo); err != nil {
+		return nil, err
+	}
+
+	// The configuration file is optional, a missing file has zero state.
+	configState := fileState{}
+	if configInfo, err := os.Stat(configFile); err == nil {
+		configState = stateOf(configInfo)
+	} else if !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "error reading CA configuration")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	loaded, exists := b.cas[ca]
+	if exists && loaded.certState == stateOf(certInfo) && loaded.keyState == stateOf(keyInfo) && loaded.configState == configState {
+		return loaded.ca, nil
+	}
+
+	cert, err := b.load(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := driver.CAConfig{}
+	if configState != (fileState{}) {
+		raw, err := ioutil.ReadFile(configFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading CA configuration")
+		}
+
+		config, err = parseCAConfig(raw)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	loadedCA, err := driver.NewCA(cert, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if exists {
+		zap.S().Infof("pki: reloaded CA %s from %s", ca, filepath.Dir(certFile))
+	}
+
+	b.cas[ca] = &fileCA{
+		ca:          loadedCA,
+		certState:   stateOf(certInfo),
+		keyState:    stateOf(keyInfo),
+		configState: configState,
+	}
+
+	return loadedCA, nil
+}
+
+// LoadCertificates returns the CA certificate chain, leaving the private
+// key untouched.
+func (b *FileBackend) LoadCertificates(ctx context.Context, ca string) ([]*x509.Certificate, error) {
+	certFile, _, err := b.files(ca)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, certificateError(err)
+	}
+
+	return parseCertificates(data)
+}
+
+// certificateError wraps an error reading the CA certificate, marking a
+// missing certificate as the CA not existing.
+func certificateError(err error) error {
+	wrapped := errors.Wrap(err, "error reading CA certificate")
+	if os.IsNotExist(err) {
+		return driver.NotFound(wrapped)
+	}
+
+	return wrapped
+}
+
+// files returns the paths of the CA's files.
+func (b *FileBackend) files(ca string) (string, string, error) {
+	if err := validateCAName(ca); err != nil {
+		return "", "", err
+	}
+
+	dir := filepath.Join(b.dir, ca)
+
+	return filepath.Join(dir, CertFile), filepath.Join(dir, KeyFile), nil
+}
+
+func (b *FileBackend) load(certFile, keyFile string) (*tls.Certificate, error) {
+	certData, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading CA certificate")
+	}
+
+	keyData, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading CA private key")
+	}
+
+	return parseKeyPair(certData, keyData, b.passphrase)
+}
+
+// checkKeyPermissions refuses private keys accessible to everyone.
+func checkKeyPermissions(keyFile string, info os.FileInfo) error {
+	if info.Mode().Perm()&0007 != 0 {
+		return errors.Errorf("CA private key %s is accessible to everyone (mode %s), restrict it with `chmod o-rwx`", keyFile, info.Mode().Perm())
+	}
+
+	return nil
+}
+
+// validateCAName refuses CA names which would escape the backend's
+// directory or path.
+func validateCAName(ca string) error {
+	if ca == "" || ca == "." || ca == ".." || strings.ContainsAny(ca, `/\`) {
+		return errors.Errorf("invalid CA name: %s", ca)
+	}
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package backend_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"docker-secretprovider-pki/backend"
+	"docker-secretprovider-pki/driver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// generateCA generates a PEM encoded self-signed CA certificate and key.
+func generateCA(cn string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).To(BeNil())
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	Expect(err).To(BeNil())
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	Expect(err).To(BeNil())
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeCA generates a self-signed CA and writes it to the CA's directory.
+func writeCA(dir, name, cn string, keyMode os.FileMode) {
+	certPEM, keyPEM := generateCA(cn)
+
+	caDir := filepath.Join(dir, name)
+	Expect(os.MkdirAll(caDir, 0700)).To(Succeed())
+	Expect(ioutil.WriteFile(filepath.Join(caDir, backend.CertFile), certPEM, 0644)).To(Succeed())
+
+	keyFile := filepath.Join(caDir, backend.KeyFile)
+	Expect(ioutil.WriteFile(keyFile, keyPEM, keyMode)).To(Succeed())
+	Expect(os.Chmod(keyFile, keyMode)).To(Succeed())
+}
+
+var _ = Describe("File backend", func() {
+	var (
+		dir string
+		ca  *backend.FileBackend
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "cas")
+		Expect(err).To(BeNil())
+
+		ca, err = backend.NewFileBackend(dir)
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should load the CA from its directory", func() {
+		writeCA(dir, "internal", "Internal CA", 0600)
+
+		cert, err := ca.Load(context.Background(), "internal")
+		Expect(err).To(BeNil())
+		Expect(cert.Chain).To(HaveLen(1))
+		Expect(cert.Signer).To(BeAssignableToTypeOf(&ecdsa.PrivateKey{}))
+
+		certs, err := ca.LoadCertificates(context.Background(), "internal")
+		Expect(err).To(BeNil())
+		Expect(certs[0].Subject.CommonName).To(Equal("Internal CA"))
+	})
+
+	It("should reload the CA when its files change", func() {
+		writeCA(dir, "internal", "Internal CA", 0600)
+
+		first, err := ca.Load(context.Background(), "internal")
+		Expect(err).To(BeNil())
+
+		again, err := ca.Load(context.Background(), "internal")
+		Expect(err).To(BeNil())
+		Expect(again).To(BeIdenticalTo(first))
+
+		writeCA(dir, "internal", "Rotated CA", 0600)
+
+		// Make sure the rotation is visible even on coarse timestamps.
+		later := time.Now().Add(time.Minute)
+		Expect(os.Chtimes(filepath.Join(dir, "internal", backend.CertFile), later, later)).To(Succeed())
+
+		rotated, err := ca.Load(context.Background(), "internal")
+		Expect(err).To(BeNil())
+
+		Expect(rotated.Chain[0].Subject.CommonName).To(Equal("Rotated CA"))
+	})
+
+	It("should not cache a key pair caught in the middle of a rotation", func() {
+		writeCA(dir, "internal", "Internal CA", 0600)
+
+		_, err := ca.Load(context.Background(), "internal")
+		Expect(err).To(BeNil())
+
+		certPEM, keyPEM := generateCA("Rotated CA")
+		certFile := filepath.Join(dir, "internal", backend.CertFile)
+		keyFile := filepath.Join(dir, "internal", backend.KeyFile)
+
+		later := time.Now().Add(time.Minute)
+		Expect(ioutil.WriteFile(certFile, certPEM, 0644)).To(Succeed())
+		Expect(os.Chtimes(certFile, later, later)).To(Succeed())
+
+		_, err = ca.Load(context.Background(), "internal")
+		Expect(err).To(MatchError("CA private key does not match CA certificate: Rotated CA"))
+
+		Expect(ioutil.WriteFile(keyFile, keyPEM, 0600)).To(Succeed())
+		Expect(os.Chtimes(keyFile, later, later)).To(Succeed())
+
+		rotated, err := ca.Load(context.Background(), "internal")
+		Expect(err).To(BeNil())
+		Expect(rotated.Chain[0].Subject.CommonName).To(Equal("Rotated CA"))
+	})
+
+	It("should load the CA's configuration", func() {
+		writeCA(dir, "internal", "Internal CA", 0600)
+		Expect(ioutil.WriteFile(filepath.Join(dir, "internal", backend.CAConfigFile), []byte(`{
+			"trust_domain": "cluster.internal",
+			"lifetime": "72h",
+			"key_type": "ecdsa",
+			"policy": {"max_lifetime": "168h"}
+		}`), 0644)).To(Succeed())
+
+		cert, err := ca.Load(context.Background(), "internal")
+		Expect(err).To(BeNil())
+		Expect(cert.Config.TrustDomain).To(Equal("cluster.internal"))
+		Expect(cert.Config.Lifetime).To(Equal(72 * time.Hour))
+		Expect(cert.Config.KeyType).To(Equal("ecdsa"))
+		Expect(cert.Config.Policy).ToNot(BeNil())
+	})
+
+	It("should refuse invalid configuration", func() {
+		writeCA(dir, "internal", "Internal CA", 0600)
+		Expect(ioutil.WriteFile(filepath.Join(dir, "internal", backend.CAConfigFile), []byte(`{"policy": {"allowed_usages": ["signing"]}}`), 0644)).To(Succeed())
+
+		_, err := ca.Load(context.Background(), "internal")
+		Expect(err.Error()).To(Equal("invalid CA policy: unknown usage: signing"))
+	})
+
+	It("should refuse world-readable private keys", func() {
+		writeCA(dir, "internal", "Internal CA", 0644)
+
+		_, err := ca.Load(context.Background(), "internal")
+		Expect(err).ToNot(BeNil())
+		Expect(err.Error()).To(ContainSubstring("is accessible to everyone (mode -rw-r--r--)"))
+	})
+
+	It("should refuse CA names escaping the directory", func() {
+		_, err := ca.Load(context.Background(), "../internal")
+		Expect(err.Error()).To(Equal("invalid CA name: ../internal"))
+	})
+
+	It("should return an error for unknown CAs", func() {
+		_, err := ca.Load(context.Background(), "unknown")
+		Expect(err.Error()).To(HavePrefix("error reading CA certificate"))
+		Expect(driver.IsNotFound(err)).To(BeTrue())
+
+		_, err = ca.LoadCertificates(context.Background(), "unknown")
+		Expect(driver.IsNotFound(err)).To(BeTrue())
+	})
+})
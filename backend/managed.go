@@ -0,0 +1,369 @@
+package backend
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"docker-secretprovider-pki/driver"
+	"docker-secretprovider-pki/duration"
+)
+
+// ManagedFile is the name of the managed backend's configuration file in
+// the plugin's config directory.
+const ManagedFile = "managed.json"
+
+// DefaultManagedDir is the directory the managed backend keeps its CAs in.
+const DefaultManagedDir = "/etc/docker/pki/managed"
+
+// Managed backend defaults.
+const (
+	DefaultManagedKeyType              = driver.KeyTypeECDSA
+	DefaultManagedRootLifetime         = 10 * 365 * 24 * time.Hour
+	DefaultManagedIntermediateLifetime = 365 * 24 * time.Hour
+)
+
+// Names of the files holding the root CA in a managed CA's directory. The
+// intermediate CA is kept in CertFile and KeyFile.
+const (
+	RootCertFile = "root.pem"
+	RootKeyFile  = "root-key.pem"
+)
+
+// ManagedConfig configures the CAs generated by the managed backend.
+type ManagedConfig struct {
+	// KeyType and KeySize specify the keys of generated CAs.
+	KeyType string `json:"key_type"`
+	KeySize int    `json:"key_size"`
+
+	RootLifetime         duration.Duration `json:"root_lifetime"`
+	IntermediateLifetime duration.Duration `json:"intermediate_lifetime"`
+
+	// RenewBefore specifies how long before expiry the intermediate CA is
+	// renewed, a third of its lifetime by default.
+	RenewBefore duration.Duration `json:"renew_before"`
+}
+
+// LoadManagedConfig reads the managed backend's configuration from a JSON
+// file.
+func LoadManagedConfig(filename string) (ManagedConfig, error) {
+	config := ManagedConfig{}
+
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return config, errors.Wrap(err, "error reading managed CA configuration")
+	}
+
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return config, errors.Wrap(err, "error parsing managed CA configuration")
+	}
+
+	return config, nil
+}
+
+// NewManagedBackend creates a backend generating its CAs in the directory.
+func NewManagedBackend(dir string, config ManagedConfig) (*ManagedBackend, error) {
+	if config.KeyType == "" && config.KeySize == 0 {
+		config.KeyType = DefaultManagedKeyType
+	}
+
+	if _, _, err := driver.NormalizeKeyParams(config.KeyType, config.KeySize); err != nil {
+		return nil, errors.Wrap(err, "invalid managed CA key")
+	}
+
+	if config.RootLifetime.Duration == 0 {
+		config.RootLifetime.Duration = DefaultManagedRootLifetime
+	}
+
+	if config.IntermediateLifetime.Duration == 0 {
+		config.IntermediateLifetime.Duration = DefaultManagedIntermediateLifetime
+	}
+
+	if config.RenewBefore.Duration == 0 {
+		config.RenewBefore.Duration = config.IntermediateLifetime.Duration / 3
+	}
+
+	if config.RootLifetime.Duration < 0 || config.IntermediateLifetime.Duration < 0 || config.RenewBefore.Duration < 0 {
+		return nil, errors.New("managed CA lifetimes can not be negative")
+	}
+
+	if config.RootLifetime.Duration <= config.IntermediateLifetime.Duration {
+		return nil, errors.New("managed root CA must outlive the intermediate CA")
+	}
+
+	if config.RenewBefore.Duration >= config.IntermediateLifetime.Duration {
+		return nil, errors.New("managed intermediate CA must be renewed before the end of its lifetime")
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "error creating managed CA directory")
+	}
+
+	return &ManagedBackend{
+		dir:    dir,
+		config: config,
+		cas:    map[string]*driver.CA{},
+	}, nil
+}
+
+// ManagedBackend is PKI backend which generates a root and an intermediate
+// CA on first use of a CA name. CA `<name>` is kept in `<dir>/<name>`, the
+// root in `root.pem` and `root-key.pem`, and the intermediate signing
+// certificates in `cert.pem` and `key.pem`. The intermediate is renewed
+// when it's about to expire.
+type ManagedBackend struct {
+	dir    string
+	config ManagedConfig
+
+	mu  sync.Mutex
+	cas map[string]*driver.CA
+}
+
+// Load returns the CA, generating it on first use and renewing the
+// intermediate CA before it expires.
+func (b *ManagedBackend) Load(ctx context.Context, ca string) (*driver.CA, error) {
+	if err := validateCAName(ca); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	loaded, exists := b.cas[ca]
+	if !exists {
+		var err error
+		loaded, err = b.load(ca)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if b.renewable(loaded) {
+		renewed, err := b.renew(ca)
+		if err != nil {
+			return nil, err
+		}
+
+		zap.S().Infof("pki: renewed intermediate CA of managed CA %s, valid until %s", ca, renewed.Certificate().NotAfter)
+		loaded = renewed
+	}
+
+	b.cas[ca] = loaded
+
+	return loaded, nil
+}
+
+// renewable reports whether the intermediate CA is due for renewal.
+func (b *ManagedBackend) renewable(ca *driver.CA) bool {
+	return time.Now().Add(b.config.RenewBefore.Duration).After(ca.Certificate().NotAfter)
+}
+
+// load reads the CA from its directory, generating it when it doesn't exist.
+func (b *ManagedBackend) load(ca string) (*driver.CA, error) {
+	dir := filepath.Join(b.dir, ca)
+
+	_, err := os.Stat(filepath.Join(dir, RootCertFile))
+	if os.IsNotExist(err) {
+		return b.generate(ca)
+	} else if err != nil {
+		return nil, errors.Wrap(err, "error reading managed root CA")
+	}
+
+	certData, err := ioutil.ReadFile(filepath.Join(dir, CertFile))
+	if os.IsNotExist(err) {
+		// Generating the CA was interrupted before the intermediate CA
+		// was written.
+		return b.renew(ca)
+	} else if err != nil {
+		return nil, errors.Wrap(err, "error reading managed intermediate CA")
+	}
+
+	keyData, err := ioutil.ReadFile(filepath.Join(dir, KeyFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading managed intermediate CA private key")
+	}
+
+	cert, err := parseKeyPair(certData, keyData, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if !keyPairMatches(cert) {
+		// Renewing the intermediate CA was interrupted between writing the
+		// new key and its certificate.
+		zap.S().Warnf("pki: private key of managed intermediate CA %s does not match its certificate, renewing it", ca)
+		return b.renew(ca)
+	}
+
+	return driver.NewCA(cert, driver.CAConfig{})
+}
+
+// keyPairMatches reports whether the private key belongs to the first
+// certificate of the key pair.
+func keyPairMatches(cert *tls.Certificate) bool {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return false
+	}
+
+	signer, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return false
+	}
+
+	return (&driver.CA{Chain: []*x509.Certificate{leaf}, Signer: signer}).Validate() == nil
+}
+
+// generate creates the root and intermediate CA of a new CA.
+func (b *ManagedBackend) generate(ca string) (*driver.CA, error) {
+	dir := filepath.Join(b.dir, ca)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "error creating managed CA directory")
+	}
+
+	root, key, err := mintRoot(pkix.Name{CommonName: ca + " Root CA"}, b.config.KeyType, b.config.KeySize, b.config.RootLifetime.Duration)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeKey(filepath.Join(dir, RootKeyFile), key); err != nil {
+		return nil, err
+	}
+
+	if err := writeCertificates(filepath.Join(dir, RootCertFile), root); err != nil {
+		return nil, err
+	}
+
+	zap.S().Infof("pki: generated root CA of managed CA %s", ca)
+
+	return b.issueIntermediate(ca, root, key)
+}
+
+// renew replaces the intermediate CA with a new one signed by the root CA.
+// The root CA is replaced too when it expires before the new intermediate
+// CA would.
+func (b *ManagedBackend) renew(ca string) (*driver.CA, error) {
+	dir := filepath.Join(b.dir, ca)
+
+	rootData, err := ioutil.ReadFile(filepath.Join(dir, RootCertFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading managed root CA")
+	}
+
+	rootKeyData, err := ioutil.ReadFile(filepath.Join(dir, RootKeyFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading managed root CA private key")
+	}
+
+	rootCert, err := parseKeyPair(rootData, rootKeyData, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if !keyPairMatches(rootCert) {
+		// Replacing the root CA was interrupted between writing the new
+		// key and its certificate.
+		zap.S().Warnf("pki: private key of managed root CA %s does not match its certificate, replacing it. Distribute the new %s to the clients", ca, RootCertFile)
+		return b.generate(ca)
+	}
+
+	root, err := driver.NewCA(rootCert, driver.CAConfig{})
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().Add(b.config.IntermediateLifetime.Duration).After(root.Certificate().NotAfter) {
+		zap.S().Warnf("pki: root CA of managed CA %s expires at %s, replacing it. Distribute the new %s to the clients", ca, root.Certificate().NotAfter, RootCertFile)
+		return b.generate(ca)
+	}
+
+	return b.issueIntermediate(ca, root.Certificate(), root.Signer)
+}
+
+// issueIntermediate creates and persists a new intermediate CA signed by
+// the root CA.
+func (b *ManagedBackend) issueIntermediate(ca string, root *x509.Certificate, rootKey crypto.Signer) (*driver.CA, error) {
+	subject := pkix.Name{CommonName: ca + " Intermediate CA"}
+
+	intermediate, key, err := mintIntermediate(subject, root, rootKey, b.config.KeyType, b.config.KeySize, b.config.IntermediateLifetime.Duration)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(b.dir, ca)
+
+	// The key is written first, so that the certificate never refers to a
+	// missing key. A crash in between leaves a mismatched key pair, which
+	// load renews.
+	if err := writeKey(filepath.Join(dir, KeyFile), key); err != nil {
+		return nil, err
+	}
+
+	if err := writeCertificates(filepath.Join(dir, CertFile), intermediate, root); err != nil {
+		return nil, err
+	}
+
+	return &driver.CA{
+		Chain:  []*x509.Certificate{intermediate, root},
+		Signer: key,
+	}, nil
+}
+
+// writeKey writes a PKCS#8 encoded private key readable by the owner only.
+func writeKey(filename string, key crypto.Signer) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return errors.Wrap(err, "error marshaling private key")
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	return errors.Wrap(writeFileAtomic(filename, data, 0600), "error writing CA private key")
+}
+
+// writeCertificates writes a PEM encoded certificate chain.
+func writeCertificates(filename string, certs ...*x509.Certificate) error {
+	var data []byte
+	for _, cert := range certs {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+
+	return errors.Wrap(writeFileAtomic(filename, data, 0644), "error writing CA certificate")
+}
+
+// writeFileAtomic replaces the file atomically.
+func writeFileAtomic(filename string, data []byte, mode os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
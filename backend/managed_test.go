@@ -0,0 +1,177 @@
+package backend_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"docker-secretprovider-pki/backend"
+	"docker-secretprovider-pki/driver"
+	"docker-secretprovider-pki/duration"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Managed backend", func() {
+	var (
+		dir    string
+		config backend.ManagedConfig
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "managed")
+		Expect(err).To(BeNil())
+
+		config = backend.ManagedConfig{}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	load := func(name string) *driver.CA {
+		b, err := backend.NewManagedBackend(dir, config)
+		Expect(err).To(BeNil())
+
+		ca, err := b.Load(context.Background(), name)
+		Expect(err).To(BeNil())
+
+		return ca
+	}
+
+	It("should generate a root and an intermediate CA on first use", func() {
+		ca := load("internal")
+		Expect(ca.Chain).To(HaveLen(2))
+
+		intermediate, root := ca.Chain[0], ca.Chain[1]
+		Expect(root.Subject.CommonName).To(Equal("internal Root CA"))
+		Expect(intermediate.Subject.CommonName).To(Equal("internal Intermediate CA"))
+		Expect(intermediate.NotAfter.Sub(intermediate.NotBefore)).To(Equal(backend.DefaultManagedIntermediateLifetime))
+
+		roots := x509.NewCertPool()
+		roots.AddCert(root)
+		_, err := intermediate.Verify(x509.VerifyOptions{Roots: roots})
+		Expect(err).To(BeNil())
+
+		info, err := os.Stat(filepath.Join(dir, "internal", backend.RootKeyFile))
+		Expect(err).To(BeNil())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+	})
+
+	It("should serve the persisted CA after restart", func() {
+		first := load("internal")
+		again := load("internal")
+
+		Expect(again.Chain[0].Raw).To(Equal(first.Chain[0].Raw))
+		Expect(again.Chain[1].Raw).To(Equal(first.Chain[1].Raw))
+	})
+
+	It("should generate distinct CAs per name", func() {
+		internal := load("internal")
+		partners := load("partners")
+
+		Expect(partners.Chain[1].Raw).ToNot(Equal(internal.Chain[1].Raw))
+	})
+
+	It("should generate keys of the configured type", func() {
+		config.KeyType = driver.KeyTypeEd25519
+
+		ca := load("internal")
+		Expect(ca.Signer).To(BeAssignableToTypeOf(ed25519.PrivateKey{}))
+	})
+
+	It("should renew the intermediate CA before it expires", func() {
+		config.IntermediateLifetime = duration.Duration{Duration: time.Hour}
+		first := load("internal")
+
+		config.RenewBefore = duration.Duration{Duration: 90 * time.Minute}
+		config.IntermediateLifetime = duration.Duration{Duration: 2 * time.Hour}
+		renewed := load("internal")
+
+		Expect(renewed.Chain[0].Raw).ToNot(Equal(first.Chain[0].Raw))
+		Expect(renewed.Chain[1].Raw).To(Equal(first.Chain[1].Raw))
+		Expect(renewed.Chain[0].NotAfter.Sub(renewed.Chain[0].NotBefore)).To(Equal(2 * time.Hour))
+
+		Expect(load("internal").Chain[0].Raw).To(Equal(renewed.Chain[0].Raw))
+	})
+
+	It("should replace a root CA expiring before the renewed intermediate CA", func() {
+		config.RootLifetime = duration.Duration{Duration: 3 * time.Hour}
+		config.IntermediateLifetime = duration.Duration{Duration: time.Hour}
+		first := load("internal")
+
+		config = backend.ManagedConfig{
+			IntermediateLifetime: duration.Duration{Duration: 4 * time.Hour},
+			RenewBefore:          duration.Duration{Duration: 3 * time.Hour},
+		}
+		renewed := load("internal")
+
+		intermediate, root := renewed.Chain[0], renewed.Chain[1]
+		Expect(root.Raw).ToNot(Equal(first.Chain[1].Raw))
+		Expect(root.NotAfter.Sub(root.NotBefore)).To(Equal(backend.DefaultManagedRootLifetime))
+		Expect(intermediate.NotAfter.Sub(intermediate.NotBefore)).To(Equal(4 * time.Hour))
+
+		roots := x509.NewCertPool()
+		roots.AddCert(root)
+		_, err := intermediate.Verify(x509.VerifyOptions{Roots: roots})
+		Expect(err).To(BeNil())
+
+		Expect(load("internal").Chain[0].Raw).To(Equal(intermediate.Raw))
+	})
+
+	It("should renew intermediate CAs whose key does not match the certificate", func() {
+		first := load("internal")
+
+		key, err := driver.GeneratePrivateKey(driver.KeyTypeECDSA, 0)
+		Expect(err).To(BeNil())
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		Expect(err).To(BeNil())
+		err = ioutil.WriteFile(filepath.Join(dir, "internal", backend.KeyFile), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+		Expect(err).To(BeNil())
+
+		renewed := load("internal")
+		Expect(renewed.Chain[0].Raw).ToNot(Equal(first.Chain[0].Raw))
+		Expect(renewed.Chain[1].Raw).To(Equal(first.Chain[1].Raw))
+		Expect(renewed.Validate()).To(Succeed())
+
+		Expect(load("internal").Chain[0].Raw).To(Equal(renewed.Chain[0].Raw))
+	})
+
+	It("should refuse root CAs not outliving the intermediate CA", func() {
+		config.RootLifetime = duration.Duration{Duration: 720 * time.Hour}
+
+		_, err := backend.NewManagedBackend(dir, config)
+		Expect(err.Error()).To(Equal("managed root CA must outlive the intermediate CA"))
+	})
+
+	It("should refuse renewing at the end of intermediate's lifetime", func() {
+		config.IntermediateLifetime = duration.Duration{Duration: time.Hour}
+		config.RenewBefore = duration.Duration{Duration: time.Hour}
+
+		_, err := backend.NewManagedBackend(dir, config)
+		Expect(err.Error()).To(Equal("managed intermediate CA must be renewed before the end of its lifetime"))
+	})
+
+	It("should refuse unsupported key types", func() {
+		config.KeyType = driver.KeyTypeRSA
+		config.KeySize = 1024
+
+		_, err := backend.NewManagedBackend(dir, config)
+		Expect(err.Error()).To(Equal("invalid managed CA key: unsupported key size for key type rsa: 1024"))
+	})
+
+	It("should refuse CA names escaping the directory", func() {
+		b, err := backend.NewManagedBackend(dir, config)
+		Expect(err).To(BeNil())
+
+		_, err = b.Load(context.Background(), "../internal")
+		Expect(err.Error()).To(Equal("invalid CA name: ../internal"))
+	})
+})
@@ -0,0 +1,91 @@
+package backend
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"docker-secretprovider-pki/driver"
+)
+
+// mintRoot creates a self-signed root CA with a new key.
+func mintRoot(subject pkix.Name, keyType string, keySize int, lifetime time.Duration) (*x509.Certificate, crypto.Signer, error) {
+	key, err := driver.GeneratePrivateKey(keyType, keySize)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error generating root CA private key")
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		Subject:               subject,
+		NotBefore:             now,
+		NotAfter:              now.Add(lifetime),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	root, err := createCertificate(template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error creating root CA certificate")
+	}
+
+	return root, key, nil
+}
+
+// mintIntermediate creates an intermediate CA with a new key, signed by the
+// root CA. The intermediate expires with the root at the latest.
+func mintIntermediate(subject pkix.Name, root *x509.Certificate, rootKey crypto.Signer, keyType string, keySize int, lifetime time.Duration) (*x509.Certificate, crypto.Signer, error) {
+	now := time.Now()
+	if !now.Before(root.NotAfter) {
+		return nil, nil, errors.Errorf("root CA %s expired at %s", root.Subject.CommonName, root.NotAfter)
+	}
+
+	key, err := driver.GeneratePrivateKey(keyType, keySize)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error generating intermediate CA private key")
+	}
+
+	notAfter := now.Add(lifetime)
+	if notAfter.After(root.NotAfter) {
+		notAfter = root.NotAfter
+	}
+
+	template := &x509.Certificate{
+		Subject:               subject,
+		NotBefore:             now,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	intermediate, err := createCertificate(template, root, key.Public(), rootKey)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error creating intermediate CA certificate")
+	}
+
+	return intermediate, key, nil
+}
+
+// createCertificate signs the template with a random serial number.
+func createCertificate(template, parent *x509.Certificate, pub crypto.PublicKey, signer crypto.Signer) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, driver.MaxSerialNumber)
+	if err != nil {
+		return nil, errors.Wrap(err, "error generating certificate serial number")
+	}
+
+	template.SerialNumber = serial
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, signer)
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.ParseCertificate(der)
+}
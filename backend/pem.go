@@ -0,0 +1,95 @@
+package backend
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+
+	"github.com/pkg/errors"
+)
+
+// parseCertificates decodes all PEM encoded certificates in data.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+
+	raw := data
+	for {
+		block, rest := pem.Decode(raw)
+		if block == nil {
+			break
+		} else if block.Type == "CERTIFICATE" {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, errors.Wrap(err, "error parsing CA certificate")
+			}
+
+			certs = append(certs, cert)
+		}
+		raw = rest
+	}
+
+	return certs, nil
+}
+
+// parsePrivateKey parses PKCS#1, PKCS#8 and EC private keys.
+func parsePrivateKey(block *pem.Block) (key interface{}, err error) {
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		err = errors.Errorf("unknown key type: %s", block.Type)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// parseKeyPair decodes a PEM encoded CA certificate chain and private key.
+// The private key is decrypted with the passphrase, unless it's nil.
+func parseKeyPair(certData, keyData, passphrase []byte) (*tls.Certificate, error) {
+	cert := &tls.Certificate{}
+
+	raw := certData
+	for {
+		block, rest := pem.Decode(raw)
+		if block == nil {
+			break
+		} else if block.Type == "CERTIFICATE" {
+			cert.Certificate = append(cert.Certificate, block.Bytes)
+		}
+		raw = rest
+	}
+
+	if len(cert.Certificate) == 0 {
+		return nil, errors.New("no CA certificates found")
+	}
+
+	block, _ := pem.Decode(keyData)
+	if block == nil {
+		return nil, errors.New("got empty decode result for private key")
+	}
+
+	if passphrase != nil {
+		var err error
+		block, err = decryptPrivateKey(block, passphrase)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := parsePrivateKey(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing private key")
+	}
+
+	cert.PrivateKey = key
+
+	return cert, nil
+}
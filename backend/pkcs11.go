@@ -0,0 +1,214 @@
+//go:build pkcs11
+// +build pkcs11
+
+package backend
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/pkg/errors"
+
+	"docker-secretprovider-pki/driver"
+)
+
+// PKCS11File is the name of the PKCS#11 backend's configuration file in the
+// plugin's config directory.
+const PKCS11File = "pkcs11.json"
+
+// PKCS11Config configures the PKCS#11 backend.
+type PKCS11Config struct {
+	// Module is the path of the PKCS#11 module of the HSM.
+	Module string `json:"module"`
+
+	// Slot or TokenLabel selects the token holding the CA keys.
+	Slot       *int   `json:"slot"`
+	TokenLabel string `json:"token_label"`
+
+	// Pin or PinFile holds the user PIN of the token.
+	Pin     string `json:"pin"`
+	PinFile string `json:"pin_file"`
+
+	// CAs configures the keys and certificates of CAs. CAs not listed use
+	// the key and certificate labeled with the CA's name.
+	CAs map[string]PKCS11CA `json:"cas"`
+}
+
+// PKCS11CA locates a CA on the token.
+type PKCS11CA struct {
+	// KeyLabel is the label of the CA's key pair, the CA's name by default.
+	KeyLabel string `json:"key_label"`
+
+	// CertFile is a PEM file of the CA's certificate chain. By default the
+	// certificate labeled like the key is read from the token.
+	CertFile string `json:"cert_file"`
+}
+
+// LoadPKCS11Config reads the PKCS#11 backend's configuration from a JSON
+// file. Relative file names in the configuration are resolved against the
+// directory of the file.
+func LoadPKCS11Config(filename string) (PKCS11Config, error) {
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return PKCS11Config{}, errors.Wrap(err, "error reading PKCS#11 configuration")
+	}
+
+	return ParsePKCS11Config(raw, filepath.Dir(filename))
+}
+
+// ParsePKCS11Config parses JSON encoded configuration of the PKCS#11
+// backend. Relative file names in the configuration are resolved against
+// dir.
+func ParsePKCS11Config(raw []byte, dir string) (PKCS11Config, error) {
+	config := PKCS11Config{}
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return config, errors.Wrap(err, "error parsing PKCS#11 configuration")
+	}
+
+	if config.PinFile != "" && !filepath.IsAbs(config.PinFile) {
+		config.PinFile = filepath.Join(dir, config.PinFile)
+	}
+
+	for name, ca := range config.CAs {
+		if ca.CertFile != "" && !filepath.IsAbs(ca.CertFile) {
+			ca.CertFile = filepath.Join(dir, ca.CertFile)
+			config.CAs[name] = ca
+		}
+	}
+
+	return config, nil
+}
+
+// NewPKCS11Backend creates a backend signing with CA keys held by an HSM.
+func NewPKCS11Backend(config PKCS11Config) (*PKCS11Backend, error) {
+	if config.Module == "" {
+		return nil, errors.New("PKCS#11 module is required")
+	}
+
+	pin := config.Pin
+	if config.PinFile != "" {
+		raw, err := ioutil.ReadFile(config.PinFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading PKCS#11 PIN file")
+		}
+
+		pin = strings.TrimSpace(string(raw))
+	}
+
+	ctx, err := crypto11.Configure(&crypto11.Config{
+		Path:       config.Module,
+		SlotNumber: config.Slot,
+		TokenLabel: config.TokenLabel,
+		Pin:        pin,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening PKCS#11 token")
+	}
+
+	return &PKCS11Backend{
+		ctx:     ctx,
+		cas:     config.CAs,
+		signers: map[string]crypto.Signer{},
+	}, nil
+}
+
+// PKCS11Backend is PKI backend which keeps CA keys in an HSM. The CA key is
+// returned as a crypto.Signer handle, so it's never exported from the HSM.
+type PKCS11Backend struct {
+	ctx *crypto11.Context
+	cas map[string]PKCS11CA
+
+	mu      sync.Mutex
+	signers map[string]crypto.Signer
+}
+
+// Load returns the CA certificate chain with a signer of the CA's key.
+func (b *PKCS11Backend) Load(ctx context.Context, ca string) (*driver.CA, error) {
+	chain, err := b.LoadCertificates(ctx, ca)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := b.signer(ca)
+	if err != nil {
+		return nil, err
+	}
+
+	loaded := &driver.CA{Chain: chain, Signer: signer}
+	if err := loaded.Validate(); err != nil {
+		return nil, err
+	}
+
+	return loaded, nil
+}
+
+// LoadCertificates returns the CA certificate chain.
+func (b *PKCS11Backend) LoadCertificates(ctx context.Context, ca string) ([]*x509.Certificate, error) {
+	config := b.config(ca)
+
+	if config.CertFile != "" {
+		raw, err := ioutil.ReadFile(config.CertFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading CA certificate")
+		}
+
+		return parseCertificates(raw)
+	}
+
+	cert, err := b.ctx.FindCertificate(nil, []byte(config.KeyLabel), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error finding CA certificate on PKCS#11 token")
+	}
+
+	if cert == nil {
+		return nil, driver.NotFound(errors.Errorf("CA certificate not found on PKCS#11 token: %s", config.KeyLabel))
+	}
+
+	return []*x509.Certificate{cert}, nil
+}
+
+// Close closes the sessions to the token.
+func (b *PKCS11Backend) Close() error {
+	return b.ctx.Close()
+}
+
+func (b *PKCS11Backend) config(ca string) PKCS11CA {
+	config := b.cas[ca]
+	if config.KeyLabel == "" {
+		config.KeyLabel = ca
+	}
+
+	return config
+}
+
+// signer returns the handle of the CA's key, finding it on first use.
+func (b *PKCS11Backend) signer(ca string) (crypto.Signer, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if signer, exists := b.signers[ca]; exists {
+		return signer, nil
+	}
+
+	label := b.config(ca).KeyLabel
+
+	signer, err := b.ctx.FindKeyPair(nil, []byte(label))
+	if err != nil {
+		return nil, errors.Wrap(err, "error finding CA key on PKCS#11 token")
+	}
+
+	if signer == nil {
+		return nil, driver.NotFound(errors.Errorf("CA key not found on PKCS#11 token: %s", label))
+	}
+
+	b.signers[ca] = signer
+
+	return signer, nil
+}
@@ -0,0 +1,146 @@
+//go:build pkcs11
+// +build pkcs11
+
+package backend_test
+
+import (
+	"context"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/ThalesIgnite/crypto11"
+
+	"docker-secretprovider-pki/backend"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// softHSMModule returns the path of SoftHSM's PKCS#11 module.
+func softHSMModule() string {
+	if module := os.Getenv("SOFTHSM2_MODULE"); module != "" {
+		return module
+	}
+
+	for _, module := range []string{
+		"/usr/lib/softhsm/libsofthsm2.so",
+		"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+		"/usr/local/lib/softhsm/libsofthsm2.so",
+	} {
+		if _, err := os.Stat(module); err == nil {
+			return module
+		}
+	}
+
+	return ""
+}
+
+var _ = Describe("PKCS#11 backend", func() {
+	const pin = "1234"
+
+	var (
+		dir    string
+		module string
+		caCert *x509.Certificate
+	)
+
+	BeforeEach(func() {
+		module = softHSMModule()
+		if module == "" {
+			Skip("SoftHSM is not installed, set SOFTHSM2_MODULE to its PKCS#11 module")
+		}
+
+		if _, err := exec.LookPath("softhsm2-util"); err != nil {
+			Skip("softhsm2-util is not installed")
+		}
+
+		var err error
+		dir, err = ioutil.TempDir("", "softhsm")
+		Expect(err).To(BeNil())
+
+		conf := filepath.Join(dir, "softhsm2.conf")
+		Expect(os.Mkdir(filepath.Join(dir, "tokens"), 0700)).To(Succeed())
+		Expect(ioutil.WriteFile(conf, []byte(fmt.Sprintf("directories.tokendir = %s/tokens\n", dir)), 0600)).To(Succeed())
+		os.Setenv("SOFTHSM2_CONF", conf)
+
+		out, err := exec.Command("softhsm2-util", "--init-token", "--free", "--label", "pki", "--pin", pin, "--so-pin", pin).CombinedOutput()
+		Expect(err).To(BeNil(), string(out))
+
+		// Generate the CA key on the token and self-sign its certificate.
+		ctx, err := crypto11.Configure(&crypto11.Config{Path: module, TokenLabel: "pki", Pin: pin})
+		Expect(err).To(BeNil())
+		defer ctx.Close()
+
+		signer, err := ctx.GenerateECDSAKeyPairWithLabel([]byte("1"), []byte("payments"), elliptic.P256())
+		Expect(err).To(BeNil())
+
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "HSM CA"},
+			NotBefore:             time.Now().Add(-time.Minute),
+			NotAfter:              time.Now().Add(time.Hour),
+			KeyUsage:              x509.KeyUsageCertSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+
+		der, err := x509.CreateCertificate(rand.Reader, template, template, signer.Public(), signer)
+		Expect(err).To(BeNil())
+
+		caCert, err = x509.ParseCertificate(der)
+		Expect(err).To(BeNil())
+		Expect(ctx.ImportCertificateWithLabel([]byte("1"), []byte("payments"), caCert)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		if dir != "" {
+			os.RemoveAll(dir)
+		}
+	})
+
+	It("should sign with the CA key on the token", func() {
+		b, err := backend.NewPKCS11Backend(backend.PKCS11Config{Module: module, TokenLabel: "pki", Pin: pin})
+		Expect(err).To(BeNil())
+		defer b.Close()
+
+		ca, err := b.Load(context.Background(), "payments")
+		Expect(err).To(BeNil())
+		Expect(ca.Chain).To(HaveLen(1))
+		Expect(ca.Chain[0].Raw).To(Equal(caCert.Raw))
+
+		signer, ok := ca.Signer.(crypto11.Signer)
+		Expect(ok).To(BeTrue())
+
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: "web"},
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, caCert.PublicKey, signer)
+		Expect(err).To(BeNil())
+
+		cert, err := x509.ParseCertificate(der)
+		Expect(err).To(BeNil())
+		Expect(cert.CheckSignatureFrom(caCert)).To(Succeed())
+	})
+
+	It("should return an error for unknown CAs", func() {
+		b, err := backend.NewPKCS11Backend(backend.PKCS11Config{Module: module, TokenLabel: "pki", Pin: pin})
+		Expect(err).To(BeNil())
+		defer b.Close()
+
+		_, err = b.Load(context.Background(), "unknown")
+		Expect(err.Error()).To(Equal("CA certificate not found on PKCS#11 token: unknown"))
+	})
+})
@@ -0,0 +1,153 @@
+package backend
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"docker-secretprovider-pki/driver"
+)
+
+// RoutingFile is the name of the routing backend's configuration file in
+// the plugin's config directory.
+const RoutingFile = "routing.json"
+
+// RoutingConfig configures the routing backend.
+type RoutingConfig struct {
+	// Backends are the backend instances CAs are routed to, by name.
+	Backends map[string]BackendConfig `json:"backends"`
+
+	// Routes map CA names to backends as `<backend>:<pattern>`, e.g.
+	// `vault:prod-*` or `file:dev`. Patterns are globs, and the first
+	// matching route is used.
+	Routes []string `json:"routes"`
+}
+
+// BackendConfig configures a backend instance.
+type BackendConfig struct {
+	// Type is the backend type, e.g. `file` or `vault`.
+	Type string `json:"type"`
+
+	// Settings are the type specific settings of the backend.
+	Settings json.RawMessage `json:"settings"`
+}
+
+// BackendFactory creates a backend of the type with its settings.
+type BackendFactory func(kind string, settings json.RawMessage) (driver.CABackend, error)
+
+// LoadRoutingConfig reads the routing backend's configuration from a JSON
+// file.
+func LoadRoutingConfig(filename string) (RoutingConfig, error) {
+	config := RoutingConfig{}
+
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return config, errors.Wrap(err, "error reading routing configuration")
+	}
+
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return config, errors.Wrap(err, "error parsing routing configuration")
+	}
+
+	return config, nil
+}
+
+// NewRoutingBackend creates the configured backends with the factory and a
+// backend routing CAs to them.
+func NewRoutingBackend(config RoutingConfig, factory BackendFactory) (*RoutingBackend, error) {
+	backends := map[string]driver.CABackend{}
+	for name, c := range config.Backends {
+		if c.Type == "" {
+			return nil, errors.Errorf("type of backend %s is required", name)
+		}
+
+		b, err := factory(c.Type, c.Settings)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error initializing backend %s", name)
+		}
+
+		backends[name] = b
+	}
+
+	r := &RoutingBackend{}
+	for _, value := range config.Routes {
+		parts := strings.SplitN(value, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.Errorf("invalid route, expected `<backend>:<pattern>`: %s", value)
+		}
+
+		b, exists := backends[parts[0]]
+		if !exists {
+			return nil, errors.Errorf("route %s refers to unknown backend: %s", value, parts[0])
+		}
+
+		if _, err := path.Match(parts[1], ""); err != nil {
+			return nil, errors.Errorf("error parsing pattern of route: %s", value)
+		}
+
+		r.routes = append(r.routes, route{pattern: parts[1], ca: b})
+	}
+
+	if len(r.routes) == 0 {
+		return nil, errors.New("no routes configured")
+	}
+
+	return r, nil
+}
+
+// RoutingBackend is PKI backend which loads CAs from other backends,
+// selected by the CA's name.
+type RoutingBackend struct {
+	routes []route
+}
+
+type route struct {
+	pattern string
+	ca      driver.CABackend
+}
+
+// Load loads the CA from the backend it's routed to.
+func (r *RoutingBackend) Load(ctx context.Context, ca string) (*driver.CA, error) {
+	b, err := r.route(ca)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Load(ctx, ca)
+}
+
+// LoadCertificates loads the CA certificate chain from the backend it's
+// routed to, leaving the private key untouched when the backend allows it.
+func (r *RoutingBackend) LoadCertificates(ctx context.Context, ca string) ([]*x509.Certificate, error) {
+	b, err := r.route(ca)
+	if err != nil {
+		return nil, err
+	}
+
+	if trust, ok := b.(driver.TrustBackend); ok {
+		return trust.LoadCertificates(ctx, ca)
+	}
+
+	loaded, err := b.Load(ctx, ca)
+	if err != nil {
+		return nil, err
+	}
+
+	return loaded.Chain, nil
+}
+
+// route returns the backend of the first route matching the CA.
+func (r *RoutingBackend) route(ca string) (driver.CABackend, error) {
+	for _, route := range r.routes {
+		if matched, _ := path.Match(route.pattern, ca); matched {
+			return route.ca, nil
+		}
+	}
+
+	return nil, driver.NotFound(errors.Errorf("no backend routed for CA %s", ca))
+}
@@ -0,0 +1,161 @@
+package backend_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"docker-secretprovider-pki/backend"
+	"docker-secretprovider-pki/driver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Routing backend", func() {
+	var (
+		config   backend.RoutingConfig
+		backends map[string]*backend.TestBackend
+		settings map[string]json.RawMessage
+	)
+
+	factory := func(kind string, raw json.RawMessage) (driver.CABackend, error) {
+		if kind != "test" {
+			return nil, errors.Errorf("unknown backend: %s", kind)
+		}
+
+		var s struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, err
+		}
+
+		b := &backend.TestBackend{}
+		backends[s.Name] = b
+		settings[s.Name] = raw
+
+		return b, nil
+	}
+
+	BeforeEach(func() {
+		backends = map[string]*backend.TestBackend{}
+		settings = map[string]json.RawMessage{}
+
+		config = backend.RoutingConfig{
+			Backends: map[string]backend.BackendConfig{
+				"vault": {Type: "test", Settings: json.RawMessage(`{"name":"vault"}`)},
+				"file":  {Type: "test", Settings: json.RawMessage(`{"name":"file"}`)},
+			},
+			Routes: []string{"vault:prod-*", "file:dev", "file:*"},
+		}
+	})
+
+	newBackend := func() *backend.RoutingBackend {
+		b, err := backend.NewRoutingBackend(config, factory)
+		Expect(err).To(BeNil())
+		return b
+	}
+
+	It("should create backends with their settings", func() {
+		newBackend()
+
+		Expect(settings).To(HaveLen(2))
+		Expect(string(settings["vault"])).To(Equal(`{"name":"vault"}`))
+		Expect(string(settings["file"])).To(Equal(`{"name":"file"}`))
+	})
+
+	It("should route CAs by name", func() {
+		b := newBackend()
+
+		ca, err := b.Load(context.Background(), "prod-web")
+		Expect(err).To(BeNil())
+		Expect(backends["vault"].Roots()).To(HaveKey("prod-web"))
+		Expect(backends["file"].Roots()).ToNot(HaveKey("prod-web"))
+
+		chain, err := b.LoadCertificates(context.Background(), "prod-web")
+		Expect(err).To(BeNil())
+		Expect(chain).To(Equal(ca.Chain))
+
+		_, err = b.Load(context.Background(), "dev")
+		Expect(err).To(BeNil())
+		Expect(backends["file"].Roots()).To(HaveKey("dev"))
+	})
+
+	It("should use the first matching route", func() {
+		config.Routes = []string{"file:*", "vault:prod-*"}
+		b := newBackend()
+
+		_, err := b.Load(context.Background(), "prod-web")
+		Expect(err).To(BeNil())
+		Expect(backends["file"].Roots()).To(HaveKey("prod-web"))
+		Expect(backends["vault"].Roots()).To(BeEmpty())
+	})
+
+	It("should fail on CAs without route", func() {
+		config.Routes = []string{"vault:prod-*"}
+		b := newBackend()
+
+		_, err := b.Load(context.Background(), "dev")
+		Expect(err).To(MatchError("no backend routed for CA dev"))
+
+		_, err = b.LoadCertificates(context.Background(), "dev")
+		Expect(err).To(MatchError("no backend routed for CA dev"))
+	})
+
+	It("should fail on invalid routes", func() {
+		for _, r := range []string{"vault", "vault:", ":prod-*"} {
+			config.Routes = []string{r}
+			_, err := backend.NewRoutingBackend(config, factory)
+			Expect(err).To(MatchError("invalid route, expected `<backend>:<pattern>`: " + r))
+		}
+
+		config.Routes = []string{"vault:prod-["}
+		_, err := backend.NewRoutingBackend(config, factory)
+		Expect(err).To(MatchError("error parsing pattern of route: vault:prod-["))
+	})
+
+	It("should fail on routes to unknown backends", func() {
+		config.Routes = []string{"hsm:prod-*"}
+		_, err := backend.NewRoutingBackend(config, factory)
+		Expect(err).To(MatchError("route hsm:prod-* refers to unknown backend: hsm"))
+	})
+
+	It("should fail without routes", func() {
+		config.Routes = nil
+		_, err := backend.NewRoutingBackend(config, factory)
+		Expect(err).To(MatchError("no routes configured"))
+	})
+
+	It("should fail on backends which can't be created", func() {
+		config.Backends["hsm"] = backend.BackendConfig{Type: "pkcs11"}
+		_, err := backend.NewRoutingBackend(config, factory)
+		Expect(err).To(MatchError("error initializing backend hsm: unknown backend: pkcs11"))
+
+		config.Backends["hsm"] = backend.BackendConfig{}
+		_, err = backend.NewRoutingBackend(config, factory)
+		Expect(err).To(MatchError("type of backend hsm is required"))
+	})
+
+	It("should load the configuration from a file", func() {
+		dir, err := ioutil.TempDir("", "routing")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+
+		filename := filepath.Join(dir, backend.RoutingFile)
+		Expect(ioutil.WriteFile(filename, []byte(`{
+			"backends": {"vault": {"type": "test", "settings": {"name": "vault"}}},
+			"routes": ["vault:prod-*"]
+		}`), 0600)).To(Succeed())
+
+		loaded, err := backend.LoadRoutingConfig(filename)
+		Expect(err).To(BeNil())
+		Expect(loaded.Routes).To(Equal([]string{"vault:prod-*"}))
+		Expect(loaded.Backends["vault"].Type).To(Equal("test"))
+		Expect(string(loaded.Backends["vault"].Settings)).To(MatchJSON(`{"name": "vault"}`))
+	})
+})
@@ -1,6 +1,7 @@
 package backend
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
@@ -9,6 +10,8 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/secrethub/secrethub-go/pkg/secrethub"
+
+	"docker-secretprovider-pki/driver"
 )
 
 // NewSecrethubBackend creates a Secrethub backend for the PKI plugin.
@@ -34,15 +37,15 @@ type SecrethubBackend struct {
 }
 
 // Load fetches the CA bundle used for issuing new certificates from Secrethub.
-func (b SecrethubBackend) Load(ca string) (*tls.Certificate, error) {
+func (b SecrethubBackend) Load(ctx context.Context, ca string) (*driver.CA, error) {
 	c, err := b.client.Secrets().Versions().GetWithData(fmt.Sprintf("%s/cert.pem", ca))
 	if err != nil {
-		return nil, errors.Wrap(err, "error fetching CA certificate")
+		return nil, secrethubError(err, "error fetching CA certificate")
 	}
 
 	k, err := b.client.Secrets().Versions().GetWithData(fmt.Sprintf("%s/key.pem", ca))
 	if err != nil {
-		return nil, errors.Wrap(err, "error fetching CA private key")
+		return nil, secrethubError(err, "error fetching CA private key")
 	}
 
 	cert := &tls.Certificate{}
@@ -59,6 +62,9 @@ func (b SecrethubBackend) Load(ca string) (*tls.Certificate, error) {
 	}
 
 	block, _ := pem.Decode(k.Data)
+	if block == nil {
+		return nil, errors.New("got empty decode result for private key")
+	}
 
 	if block.Type == "RSA PRIVATE KEY" {
 		cert.PrivateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
@@ -70,9 +76,47 @@ func (b SecrethubBackend) Load(ca string) (*tls.Certificate, error) {
 		if err != nil {
 			return nil, errors.Wrap(err, "error parsing PKCS8 private key")
 		}
+	} else if block.Type == "EC PRIVATE KEY" {
+		cert.PrivateKey, err = x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing EC private key")
+		}
 	} else {
 		return nil, errors.New(fmt.Sprintf("received unknown PEM type for private key: %s", block.Type))
 	}
 
-	return cert, nil
+	return driver.NewCA(cert, driver.CAConfig{})
+}
+
+// LoadCertificates fetches the CA certificate chain from Secrethub, leaving
+// the private key untouched.
+func (b SecrethubBackend) LoadCertificates(ctx context.Context, ca string) ([]*x509.Certificate, error) {
+	c, err := b.client.Secrets().Versions().GetWithData(fmt.Sprintf("%s/cert.pem", ca))
+	if err != nil {
+		return nil, secrethubError(err, "error fetching CA certificate")
+	}
+
+	return parseCertificates(c.Data)
+}
+
+// secrethubNotFound lists the types of Secrethub's API errors for paths
+// which don't exist.
+var secrethubNotFound = map[string]bool{
+	"server.secret_not_found":  true,
+	"server.version_not_found": true,
+	"server.dir_not_found":     true,
+	"server.repo_not_found":    true,
+	"api.namespace_not_found":  true,
+}
+
+// secrethubError wraps an error fetching a secret, marking a missing secret
+// as the CA not existing. Secrethub's API errors are matched by their public
+// type, e.g. `server.secret_not_found`.
+func secrethubError(err error, message string) error {
+	wrapped := errors.Wrap(err, message)
+	if typed, ok := err.(interface{ Type() string }); ok && secrethubNotFound[typed.Type()] {
+		return driver.NotFound(wrapped)
+	}
+
+	return wrapped
 }
@@ -0,0 +1,104 @@
+package backend_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"docker-secretprovider-pki/backend"
+	"docker-secretprovider-pki/driver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Test backend", func() {
+	var b *backend.TestBackend
+
+	BeforeEach(func() {
+		var err error
+		b, err = backend.NewTestBackend(backend.TestConfig{
+			KeyType:  driver.KeyTypeECDSA,
+			Validity: 2 * time.Hour,
+			CAs:      []string{"internal"},
+		})
+		Expect(err).To(BeNil())
+	})
+
+	verify := func(ca *driver.CA, root *x509.Certificate) error {
+		roots := x509.NewCertPool()
+		roots.AddCert(root)
+
+		_, err := ca.Chain[0].Verify(x509.VerifyOptions{Roots: roots})
+		return err
+	}
+
+	It("should mint configured CAs at startup", func() {
+		Expect(b.Roots()).To(HaveKey("internal"))
+	})
+
+	It("should mint CAs with the configured key and validity", func() {
+		ca, err := b.Load(context.Background(), "internal")
+		Expect(err).To(BeNil())
+		Expect(ca.Chain).To(HaveLen(2))
+		Expect(ca.Signer).To(BeAssignableToTypeOf(&ecdsa.PrivateKey{}))
+
+		for _, cert := range ca.Chain {
+			Expect(cert.NotAfter.Sub(cert.NotBefore)).To(Equal(2 * time.Hour))
+		}
+	})
+
+	It("should serve the same CA on later loads", func() {
+		first, err := b.Load(context.Background(), "internal")
+		Expect(err).To(BeNil())
+
+		again, err := b.Load(context.Background(), "internal")
+		Expect(err).To(BeNil())
+		Expect(again).To(BeIdenticalTo(first))
+	})
+
+	It("should mint isolated hierarchies per CA name", func() {
+		internal, err := b.Load(context.Background(), "internal")
+		Expect(err).To(BeNil())
+
+		partners, err := b.Load(context.Background(), "partners")
+		Expect(err).To(BeNil())
+
+		roots := b.Roots()
+		Expect(roots).To(HaveLen(2))
+		Expect(roots["partners"].Raw).ToNot(Equal(roots["internal"].Raw))
+
+		Expect(verify(internal, roots["internal"])).To(Succeed())
+		Expect(verify(partners, roots["partners"])).To(Succeed())
+		Expect(verify(internal, roots["partners"])).ToNot(Succeed())
+		Expect(verify(partners, roots["internal"])).ToNot(Succeed())
+	})
+
+	It("should load the configuration from a file", func() {
+		dir, err := ioutil.TempDir("", "test")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+
+		filename := filepath.Join(dir, backend.TestFile)
+		Expect(ioutil.WriteFile(filename, []byte(`{"key_type": "ecdsa", "key_size": 384, "validity": "720h", "cas": ["internal"]}`), 0600)).To(Succeed())
+
+		config, err := backend.LoadTestConfig(filename)
+		Expect(err).To(BeNil())
+		Expect(config).To(Equal(backend.TestConfig{
+			KeyType:  driver.KeyTypeECDSA,
+			KeySize:  384,
+			Validity: 720 * time.Hour,
+			CAs:      []string{"internal"},
+		}))
+	})
+
+	It("should mint CAs with the zero value", func() {
+		ca, err := (&backend.TestBackend{}).Load(context.Background(), "test")
+		Expect(err).To(BeNil())
+		Expect(ca.Chain[0].Subject.CommonName).To(Equal("PKI Provider Test Intermediate CA test"))
+	})
+})
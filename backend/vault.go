@@ -0,0 +1,374 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"docker-secretprovider-pki/driver"
+)
+
+// VaultFile is the name of the Vault backend's configuration file in the
+// plugin's config directory.
+const VaultFile = "vault.json"
+
+// Vault backend defaults.
+const (
+	DefaultVaultPath    = "secret/data/pki/{{.CA}}"
+	DefaultVaultTimeout = 10 * time.Second
+)
+
+// Fields of Vault KV secrets holding the CA. The optional config field holds
+// JSON encoded settings of the CA.
+const (
+	VaultCertField   = "cert"
+	VaultKeyField    = "key"
+	VaultConfigField = "config"
+)
+
+// VaultConfig configures the Vault backend.
+type VaultConfig struct {
+	// Address is the URL of the Vault server, e.g. `https://vault:8200`.
+	Address string `json:"address"`
+
+	// Path is a template of the KV path holding a CA, e.g.
+	// `secret/data/pki/{{.CA}}` for KV v2 mounted at `secret`.
+	Path string `json:"path"`
+
+	// KVVersion is the version of the KV secrets engine, 1 or 2.
+	KVVersion int `json:"kv_version"`
+
+	// CACert is a PEM file of CA certificates trusted for the Vault server.
+	CACert string `json:"ca_cert"`
+
+	Auth VaultAuthConfig `json:"auth"`
+
+	// PKI maps CAs to roles of Vault's PKI secrets engine signing their
+	// certificates.
+	PKI map[string]VaultPKIRole `json:"pki"`
+}
+
+// LoadVaultConfig reads the Vault backend's configuration from a JSON file.
+// Relative file names in the configuration are resolved against the
+// directory of the file.
+func LoadVaultConfig(filename string) (VaultConfig, error) {
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return VaultConfig{}, errors.Wrap(err, "error reading Vault configuration")
+	}
+
+	return ParseVaultConfig(raw, filepath.Dir(filename))
+}
+
+// ParseVaultConfig parses JSON encoded configuration of the Vault backend.
+// Relative file names in the configuration are resolved against dir.
+func ParseVaultConfig(raw []byte, dir string) (VaultConfig, error) {
+	config := VaultConfig{}
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return config, errors.Wrap(err, "error parsing Vault configuration")
+	}
+
+	for _, name := range []*string{&config.CACert, &config.Auth.TokenFile, &config.Auth.SecretIDFile} {
+		if *name != "" && !filepath.IsAbs(*name) {
+			*name = filepath.Join(dir, *name)
+		}
+	}
+
+	return config, nil
+}
+
+// NewVaultBackend creates a backend loading CAs from Vault's KV secrets
+// engine.
+func NewVaultBackend(config VaultConfig) (*VaultBackend, error) {
+	if config.Address == "" {
+		return nil, errors.New("Vault address is required")
+	}
+
+	if config.Path == "" {
+		config.Path = DefaultVaultPath
+	}
+
+	if config.KVVersion == 0 {
+		config.KVVersion = 2
+	}
+
+	if config.KVVersion != 1 && config.KVVersion != 2 {
+		return nil, errors.Errorf("unsupported Vault KV version: %d", config.KVVersion)
+	}
+
+	path, err := template.New("vault").Option("missingkey=error").Parse(config.Path)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing Vault path template")
+	}
+
+	client, err := newVaultClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VaultBackend{
+		client:    client,
+		path:      path,
+		kvVersion: config.KVVersion,
+	}, nil
+}
+
+// VaultBackend is PKI backend which loads CAs from Vault's KV secrets
+// engine. The CA certificate chain and private key are read from PEM
+// encoded `cert` and `key` fields of the secret.
+type VaultBackend struct {
+	client    *vaultClient
+	path      *template.Template
+	kvVersion int
+}
+
+// Load fetches the CA bundle used for issuing new certificates from Vault.
+func (b *VaultBackend) Load(ctx context.Context, ca string) (*driver.CA, error) {
+	data, err := b.read(ctx, ca)
+	if err != nil {
+		return nil, err
+	}
+
+	if data[VaultKeyField] == "" {
+		return nil, errors.Errorf("CA private key not found in Vault field: %s", VaultKeyField)
+	}
+
+	cert, err := parseKeyPair([]byte(data[VaultCertField]), []byte(data[VaultKeyField]), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	config := driver.CAConfig{}
+	if data[VaultConfigField] != "" {
+		config, err = parseCAConfig([]byte(data[VaultConfigField]))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return driver.NewCA(cert, config)
+}
+
+// LoadCertificates fetches the CA certificate chain from Vault, leaving
+// the private key untouched.
+func (b *VaultBackend) LoadCertificates(ctx context.Context, ca string) ([]*x509.Certificate, error) {
+	data, err := b.read(ctx, ca)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseCertificates([]byte(data[VaultCertField]))
+}
+
+// Close stops renewing the Vault token in the background.
+func (b *VaultBackend) Close() error {
+	b.client.close()
+	return nil
+}
+
+// read returns the fields of the CA's KV secret.
+func (b *VaultBackend) read(ctx context.Context, ca string) (map[string]string, error) {
+	if err := validateCAName(ca); err != nil {
+		return nil, err
+	}
+
+	path := &bytes.Buffer{}
+	if err := b.path.Execute(path, struct{ CA string }{CA: ca}); err != nil {
+		return nil, errors.Wrap(err, "error rendering Vault path")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, DefaultVaultTimeout)
+	defer cancel()
+
+	token, err := b.client.auth.token(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error fetching CA from Vault")
+	}
+
+	secret, err := b.client.do(ctx, http.MethodGet, strings.Trim(path.String(), "/"), token, nil)
+	if err != nil {
+		// Only a missing KV secret means the CA doesn't exist, paths missing
+		// while authenticating are configuration errors.
+		if vaultErr, ok := err.(*vaultError); ok && vaultErr.status == http.StatusNotFound {
+			return nil, driver.NotFound(errors.Wrap(err, "error fetching CA from Vault"))
+		}
+
+		return nil, errors.Wrap(err, "error fetching CA from Vault")
+	}
+
+	raw := secret.Data
+	if b.kvVersion == 2 {
+		var kv2 struct {
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(raw, &kv2); err != nil {
+			return nil, errors.Wrap(err, "error parsing Vault KV v2 secret")
+		}
+
+		raw = kv2.Data
+	}
+
+	var data map[string]string
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, errors.Wrap(err, "error parsing Vault secret")
+	}
+
+	if data[VaultCertField] == "" {
+		return nil, errors.Errorf("CA certificate not found in Vault field: %s", VaultCertField)
+	}
+
+	return data, nil
+}
+
+// vaultSecret is the response of Vault's API.
+type vaultSecret struct {
+	Data   json.RawMessage `json:"data"`
+	Auth   *vaultAuth      `json:"auth"`
+	Errors []string        `json:"errors"`
+}
+
+type vaultAuth struct {
+	ClientToken   string `json:"client_token"`
+	LeaseDuration int    `json:"lease_duration"`
+	Renewable     bool   `json:"renewable"`
+}
+
+// vaultClient calls Vault's HTTP API.
+type vaultClient struct {
+	address string
+	http    *http.Client
+	auth    *vaultAuthenticator
+}
+
+func newVaultClient(config VaultConfig) (*vaultClient, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if config.CACert != "" {
+		raw, err := ioutil.ReadFile(config.CACert)
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading Vault CA certificate")
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(raw) {
+			return nil, errors.Errorf("no certificates found in Vault CA certificate: %s", config.CACert)
+		}
+
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	c := &vaultClient{
+		address: strings.TrimRight(config.Address, "/"),
+		http:    &http.Client{Transport: transport},
+	}
+
+	auth, err := newVaultAuthenticator(c, config.Auth)
+	if err != nil {
+		return nil, err
+	}
+
+	c.auth = auth
+
+	return c, nil
+}
+
+// close stops renewing the client's token.
+func (c *vaultClient) close() {
+	c.auth.close()
+}
+
+// read reads a secret, authenticating first.
+func (c *vaultClient) read(ctx context.Context, path string) (*vaultSecret, error) {
+	token, err := c.auth.token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.do(ctx, http.MethodGet, path, token, nil)
+}
+
+// write writes data to a path, authenticating first.
+func (c *vaultClient) write(ctx context.Context, path string, data interface{}) (*vaultSecret, error) {
+	token, err := c.auth.token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.do(ctx, http.MethodPost, path, token, data)
+}
+
+// do calls Vault's API with the token.
+func (c *vaultClient) do(ctx context.Context, method, path, token string, data interface{}) (*vaultSecret, error) {
+	body := bytes.NewReader(nil)
+	if data != nil {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return nil, errors.Wrap(err, "error encoding Vault request")
+		}
+
+		body = bytes.NewReader(raw)
+	}
+
+	req, err := http.NewRequest(method, c.address+"/v1/"+path, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating Vault request")
+	}
+
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+
+	resp, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "error calling Vault")
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading Vault response")
+	}
+
+	secret := &vaultSecret{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, secret); err != nil {
+			return nil, errors.Wrapf(err, "error parsing Vault response (status %d)", resp.StatusCode)
+		}
+	}
+
+	if resp.StatusCode >= 400 {
+		return nil, &vaultError{path: path, status: resp.StatusCode, errors: secret.Errors}
+	}
+
+	return secret, nil
+}
+
+// vaultError is an error status returned by Vault's API.
+type vaultError struct {
+	path   string
+	status int
+	errors []string
+}
+
+func (e *vaultError) Error() string {
+	if e.status == http.StatusNotFound {
+		return fmt.Sprintf("Vault path not found: %s", e.path)
+	}
+
+	if len(e.errors) > 0 {
+		return fmt.Sprintf("Vault returned status %d: %s", e.status, strings.Join(e.errors, ", "))
+	}
+
+	return fmt.Sprintf("Vault returned status %d", e.status)
+}
@@ -0,0 +1,336 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Vault authentication methods.
+const (
+	VaultAuthToken   = "token"
+	VaultAuthAppRole = "approle"
+	VaultAuthFile    = "file"
+)
+
+// VaultAuthConfig configures authentication to Vault.
+type VaultAuthConfig struct {
+	// Method is one of `token`, `approle` or `file`.
+	Method string `json:"method"`
+
+	// Token authenticates with the `token` method.
+	Token string `json:"token"`
+
+	// TokenFile holds the token of the `token` method, or the token kept
+	// up to date by e.g. Vault Agent for the `file` method.
+	TokenFile string `json:"token_file"`
+
+	// RoleID, SecretID or SecretIDFile authenticate with `approle` method
+	// mounted at Mount, `approle` by default.
+	RoleID       string `json:"role_id"`
+	SecretID     string `json:"secret_id"`
+	SecretIDFile string `json:"secret_id_file"`
+	Mount        string `json:"mount"`
+}
+
+// Background renewals failing are retried after vaultRenewRetry, doubling
+// the delay up to vaultRenewMaxRetry.
+const (
+	vaultRenewRetry    = 5 * time.Second
+	vaultRenewMaxRetry = 5 * time.Minute
+)
+
+// vaultAuthenticator keeps a valid Vault token. Tokens with a lease are
+// renewed when a third of the lease is left, AppRole logins are repeated
+// when the token can not be renewed anymore. Tokens are renewed in the
+// background, so that leases don't expire while the plugin is idle.
+type vaultAuthenticator struct {
+	client *vaultClient
+	config VaultAuthConfig
+
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mu        sync.Mutex
+	current   string
+	lease     time.Duration
+	expires   time.Time
+	renewable bool
+}
+
+func newVaultAuthenticator(client *vaultClient, config VaultAuthConfig) (*vaultAuthenticator, error) {
+	switch config.Method {
+	case VaultAuthToken:
+		if config.Token == "" && config.TokenFile == "" {
+			return nil, errors.New("Vault token authentication requires token or token_file")
+		}
+	case VaultAuthAppRole:
+		if config.RoleID == "" {
+			return nil, errors.New("Vault AppRole authentication requires role_id")
+		}
+
+		if config.Mount == "" {
+			config.Mount = "approle"
+		}
+	case VaultAuthFile:
+		if config.TokenFile == "" {
+			return nil, errors.New("Vault file authentication requires token_file")
+		}
+	default:
+		return nil, errors.Errorf("unknown Vault authentication method: %s", config.Method)
+	}
+
+	a := &vaultAuthenticator{client: client, config: config, stop: make(chan struct{})}
+
+	// Tokens of the file method are managed externally.
+	if config.Method != VaultAuthFile {
+		go a.keepAlive()
+	}
+
+	return a, nil
+}
+
+// keepAlive renews the token when two thirds of its lease have passed,
+// until the authenticator is closed or the token can't be renewed anymore.
+func (a *vaultAuthenticator) keepAlive() {
+	var backoff time.Duration
+
+	for {
+		wait, renewable := a.renewIn()
+		if !renewable {
+			zap.S().Warnf("pki: Vault token can not be renewed, it expires at %s", a.expiry())
+			return
+		}
+
+		if wait < backoff {
+			wait = backoff
+		}
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-timer.C:
+			ctx, cancel := context.WithTimeout(context.Background(), DefaultVaultTimeout)
+			a.mu.Lock()
+			err := a.refresh(ctx)
+			a.mu.Unlock()
+			cancel()
+
+			if err == nil {
+				backoff = 0
+				continue
+			}
+
+			backoff *= 2
+			if backoff == 0 {
+				backoff = vaultRenewRetry
+			} else if backoff > vaultRenewMaxRetry {
+				backoff = vaultRenewMaxRetry
+			}
+
+			zap.S().Errorf("pki: error renewing Vault token in the background, retrying in %s: %s", backoff, err)
+		case <-a.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// renewIn returns the time until the token is due for renewal, and false
+// when a static token without renewable lease is due. Tokens are obtained
+// on first use, tokens without a lease are checked every minute.
+func (a *vaultAuthenticator) renewIn() (time.Duration, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.current == "" || a.expires.IsZero() {
+		return time.Minute, true
+	}
+
+	if wait := time.Until(a.expires) - a.lease/3; wait > 0 {
+		return wait, true
+	}
+
+	if !a.renewable && a.config.Method == VaultAuthToken && a.config.TokenFile == "" {
+		return 0, false
+	}
+
+	return vaultRenewRetry, true
+}
+
+func (a *vaultAuthenticator) expiry() time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.expires
+}
+
+// close stops renewing the token in the background.
+func (a *vaultAuthenticator) close() {
+	a.stopOnce.Do(func() { close(a.stop) })
+}
+
+// token returns a valid token, authenticating or renewing it when needed.
+// Tokens failing to renew are used until they expire.
+func (a *vaultAuthenticator) token(ctx context.Context) (string, error) {
+	// Tokens of the file method are managed externally.
+	if a.config.Method == VaultAuthFile {
+		return readSecretFile(a.config.TokenFile)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.refresh(ctx); err != nil {
+		if a.current == "" || (!a.expires.IsZero() && time.Now().After(a.expires)) {
+			return "", err
+		}
+
+		zap.S().Warnf("pki: error renewing Vault token, expiring at %s: %s", a.expires, err)
+	}
+
+	return a.current, nil
+}
+
+// refresh authenticates on first use, and renews the token when a third of
+// its lease is left. Tokens which can't be renewed are replaced by a new
+// AppRole login or a new token of the token file.
+func (a *vaultAuthenticator) refresh(ctx context.Context) error {
+	if a.current == "" {
+		return a.authenticate(ctx)
+	}
+
+	if a.expires.IsZero() || time.Until(a.expires) > a.lease/3 {
+		return nil
+	}
+
+	err := errors.New("token is not renewable")
+	if a.renewable {
+		if err = a.renew(ctx); err == nil {
+			return nil
+		}
+	}
+
+	if a.config.Method == VaultAuthAppRole {
+		zap.S().Infof("pki: logging in to Vault again: %s", err)
+		return a.authenticate(ctx)
+	}
+
+	if a.config.TokenFile != "" {
+		if token, readErr := readSecretFile(a.config.TokenFile); readErr == nil && token != a.current {
+			zap.S().Infof("pki: using the new Vault token of %s: %s", a.config.TokenFile, err)
+			return a.authenticate(ctx)
+		}
+	}
+
+	if time.Now().After(a.expires) {
+		return errors.Wrap(err, "Vault token expired")
+	}
+
+	return err
+}
+
+// authenticate obtains the token and its lease.
+func (a *vaultAuthenticator) authenticate(ctx context.Context) error {
+	if a.config.Method == VaultAuthAppRole {
+		return a.login(ctx)
+	}
+
+	token := a.config.Token
+	if token == "" {
+		var err error
+		if token, err = readSecretFile(a.config.TokenFile); err != nil {
+			return err
+		}
+	}
+
+	secret, err := a.client.do(ctx, http.MethodGet, "auth/token/lookup-self", token, nil)
+	if err != nil {
+		return errors.Wrap(err, "error looking up Vault token")
+	}
+
+	var data struct {
+		TTL       int  `json:"ttl"`
+		Renewable bool `json:"renewable"`
+	}
+	if err := json.Unmarshal(secret.Data, &data); err != nil {
+		return errors.Wrap(err, "error parsing Vault token")
+	}
+
+	a.setToken(token, data.TTL, data.Renewable)
+
+	return nil
+}
+
+// login logs in with AppRole.
+func (a *vaultAuthenticator) login(ctx context.Context) error {
+	secretID := a.config.SecretID
+	if a.config.SecretIDFile != "" {
+		var err error
+		if secretID, err = readSecretFile(a.config.SecretIDFile); err != nil {
+			return err
+		}
+	}
+
+	secret, err := a.client.do(ctx, http.MethodPost, "auth/"+a.config.Mount+"/login", "", map[string]string{
+		"role_id":   a.config.RoleID,
+		"secret_id": secretID,
+	})
+	if err != nil {
+		return errors.Wrap(err, "error logging in to Vault")
+	}
+
+	if secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return errors.New("error logging in to Vault: no token returned")
+	}
+
+	a.setToken(secret.Auth.ClientToken, secret.Auth.LeaseDuration, secret.Auth.Renewable)
+
+	return nil
+}
+
+// renew extends the token's lease.
+func (a *vaultAuthenticator) renew(ctx context.Context) error {
+	secret, err := a.client.do(ctx, http.MethodPost, "auth/token/renew-self", a.current, map[string]string{})
+	if err != nil {
+		return errors.Wrap(err, "error renewing Vault token")
+	}
+
+	if secret.Auth == nil {
+		return errors.New("error renewing Vault token: no lease returned")
+	}
+
+	a.setToken(a.current, secret.Auth.LeaseDuration, secret.Auth.Renewable)
+
+	return nil
+}
+
+func (a *vaultAuthenticator) setToken(token string, ttl int, renewable bool) {
+	a.current = token
+	a.renewable = renewable
+	a.lease = time.Duration(ttl) * time.Second
+
+	// Tokens without TTL, e.g. root tokens, do not expire.
+	if ttl > 0 {
+		a.expires = time.Now().Add(a.lease)
+	} else {
+		a.expires = time.Time{}
+	}
+}
+
+// readSecretFile reads a token or secret ID from a file.
+func readSecretFile(filename string) (string, error) {
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", errors.Wrap(err, "error reading Vault credential file")
+	}
+
+	return strings.TrimSpace(string(raw)), nil
+}
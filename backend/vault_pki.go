@@ -0,0 +1,179 @@
+package backend
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"docker-secretprovider-pki/driver"
+)
+
+// VaultPKIRole selects the PKI secrets engine role signing a CA's
+// certificates.
+type VaultPKIRole struct {
+	// Mount is the path the PKI secrets engine is mounted at, e.g. `pki`.
+	Mount string `json:"mount"`
+
+	// Role is the name of the role used for signing.
+	Role string `json:"role"`
+
+	// Verbatim signs the CSR with `sign-verbatim`, taking the names from
+	// the CSR as is instead of checking them against the role.
+	Verbatim bool `json:"verbatim"`
+}
+
+// NewVaultSigner creates a signing backend delegating signing to roles of
+// Vault's PKI secrets engine.
+func NewVaultSigner(config VaultConfig) (*VaultSigner, error) {
+	if config.Address == "" {
+		return nil, errors.New("Vault address is required")
+	}
+
+	for name, role := range config.PKI {
+		if role.Mount == "" || role.Role == "" {
+			return nil, errors.Errorf("Vault PKI mount and role are required for CA: %s", name)
+		}
+	}
+
+	client, err := newVaultClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VaultSigner{
+		client: client,
+		roles:  config.PKI,
+	}, nil
+}
+
+// VaultSigner signs certificates with Vault's PKI secrets engine, so the CA
+// keys never leave Vault.
+type VaultSigner struct {
+	client *vaultClient
+	roles  map[string]VaultPKIRole
+}
+
+// Signs reports whether a PKI role is configured for the CA.
+func (s *VaultSigner) Signs(ca string) bool {
+	_, exists := s.roles[ca]
+	return exists
+}
+
+// Sign signs the CSR with the CA's role.
+func (s *VaultSigner) Sign(ctx context.Context, ca string, csr *x509.CertificateRequest, config driver.CertRequest) (*x509.Certificate, []*x509.Certificate, error) {
+	role, exists := s.roles[ca]
+	if !exists {
+		return nil, nil, errors.Errorf("no Vault PKI role configured for CA: %s", ca)
+	}
+
+	data := map[string]interface{}{
+		"csr":    string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr.Raw})),
+		"ttl":    fmt.Sprintf("%ds", int64(config.Lifetime.Seconds())),
+		"format": "pem",
+	}
+
+	path := role.Mount + "/sign-verbatim/" + role.Role
+	if role.Verbatim {
+		var usages []string
+		for _, usage := range config.Usage {
+			switch usage {
+			case x509.ExtKeyUsageServerAuth:
+				usages = append(usages, "ServerAuth")
+			case x509.ExtKeyUsageClientAuth:
+				usages = append(usages, "ClientAuth")
+			}
+		}
+
+		data["ext_key_usage"] = usages
+	} else {
+		path = role.Mount + "/sign/" + role.Role
+
+		data["common_name"] = config.CommonName
+		data["alt_names"] = strings.Join(csr.DNSNames, ",")
+
+		var ipSANs, uriSANs []string
+		for _, addr := range csr.IPAddresses {
+			ipSANs = append(ipSANs, addr.String())
+		}
+		for _, uri := range csr.URIs {
+			uriSANs = append(uriSANs, uri.String())
+		}
+
+		data["ip_sans"] = strings.Join(ipSANs, ",")
+		data["uri_sans"] = strings.Join(uriSANs, ",")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, DefaultVaultTimeout)
+	defer cancel()
+
+	secret, err := s.client.write(ctx, path, data)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error signing certificate with Vault")
+	}
+
+	var signed struct {
+		Certificate string   `json:"certificate"`
+		IssuingCA   string   `json:"issuing_ca"`
+		CAChain     []string `json:"ca_chain"`
+	}
+	if err := json.Unmarshal(secret.Data, &signed); err != nil {
+		return nil, nil, errors.Wrap(err, "error parsing Vault signing response")
+	}
+
+	certs, err := parseCertificates([]byte(signed.Certificate))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(certs) == 0 {
+		return nil, nil, errors.New("Vault returned no certificate")
+	}
+
+	chainPEM := strings.Join(signed.CAChain, "\n")
+	if chainPEM == "" {
+		chainPEM = signed.IssuingCA
+	}
+
+	chain, err := parseCertificates([]byte(chainPEM))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return certs[0], chain, nil
+}
+
+// LoadCertificates fetches the CA chain of the CA's PKI secrets engine.
+func (s *VaultSigner) LoadCertificates(ctx context.Context, ca string) ([]*x509.Certificate, error) {
+	role, exists := s.roles[ca]
+	if !exists {
+		return nil, errors.Errorf("no Vault PKI role configured for CA: %s", ca)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, DefaultVaultTimeout)
+	defer cancel()
+
+	secret, err := s.client.read(ctx, role.Mount+"/cert/ca_chain")
+	if err != nil {
+		return nil, errors.Wrap(err, "error fetching CA chain from Vault")
+	}
+
+	var data struct {
+		Certificate string `json:"certificate"`
+	}
+	if err := json.Unmarshal(secret.Data, &data); err != nil {
+		return nil, errors.Wrap(err, "error parsing Vault CA chain")
+	}
+
+	return parseCertificates([]byte(data.Certificate))
+}
+
+// Close stops renewing the Vault token in the background.
+func (s *VaultSigner) Close() error {
+	s.client.close()
+	return nil
+}
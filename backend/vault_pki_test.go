@@ -0,0 +1,165 @@
+package backend_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"docker-secretprovider-pki/backend"
+	"docker-secretprovider-pki/driver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Vault PKI signer", func() {
+	var (
+		vault    *fakeVault
+		server   *httptest.Server
+		caCert   *x509.Certificate
+		caPEM    string
+		requests map[string]map[string]interface{}
+		signer   *backend.VaultSigner
+		config   driver.CertRequest
+		csr      *x509.CertificateRequest
+		key      crypto.Signer
+	)
+
+	BeforeEach(func() {
+		certPEM, keyPEM := generateCA("Vault Intermediate CA")
+		caPEM = string(certPEM)
+
+		block, _ := pem.Decode(certPEM)
+		var err error
+		caCert, err = x509.ParseCertificate(block.Bytes)
+		Expect(err).To(BeNil())
+
+		block, _ = pem.Decode(keyPEM)
+		caKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		Expect(err).To(BeNil())
+
+		requests = map[string]map[string]interface{}{}
+
+		vault = newFakeVault()
+		vault.pki = func(path string, data map[string]interface{}) (int, interface{}) {
+			requests[path] = data
+
+			if path == "cert/ca_chain" {
+				return http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"certificate": caPEM}}
+			}
+
+			if path != "sign/web" && path != "sign-verbatim/web" {
+				return http.StatusNotFound, map[string]interface{}{"errors": []string{}}
+			}
+
+			block, _ := pem.Decode([]byte(data["csr"].(string)))
+			csr, err := x509.ParseCertificateRequest(block.Bytes)
+			Expect(err).To(BeNil())
+
+			template := &x509.Certificate{
+				SerialNumber: big.NewInt(7),
+				Subject:      csr.Subject,
+				DNSNames:     csr.DNSNames,
+				NotBefore:    time.Now(),
+				NotAfter:     time.Now().Add(time.Hour),
+			}
+
+			der, err := x509.CreateCertificate(rand.Reader, template, caCert, csr.PublicKey, caKey)
+			Expect(err).To(BeNil())
+
+			return http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+				"certificate": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+				"issuing_ca":  caPEM,
+				"ca_chain":    []string{caPEM},
+			}}
+		}
+
+		server = httptest.NewServer(vault)
+
+		signer, err = backend.NewVaultSigner(backend.VaultConfig{
+			Address: server.URL,
+			Auth:    backend.VaultAuthConfig{Method: "token", Token: "root"},
+			PKI: map[string]backend.VaultPKIRole{
+				"payments": {Mount: "pki", Role: "web"},
+				"legacy":   {Mount: "pki", Role: "web", Verbatim: true},
+			},
+		})
+		Expect(err).To(BeNil())
+
+		config = driver.CertRequest{
+			CAName:     "payments",
+			CommonName: "api",
+			DNSNames:   []string{"api.payments.internal"},
+			Usage:      []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			Lifetime:   time.Hour,
+		}
+
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).To(BeNil())
+
+		der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+			Subject:  pkix.Name{CommonName: "api"},
+			DNSNames: []string{"api.payments.internal"},
+		}, key)
+		Expect(err).To(BeNil())
+
+		csr, err = x509.ParseCertificateRequest(der)
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should sign for configured CAs only", func() {
+		Expect(signer.Signs("payments")).To(BeTrue())
+		Expect(signer.Signs("internal")).To(BeFalse())
+	})
+
+	It("should sign the CSR with the role", func() {
+		cert, chain, err := signer.Sign(context.Background(), "payments", csr, config)
+		Expect(err).To(BeNil())
+
+		Expect(cert.Subject.CommonName).To(Equal("api"))
+		Expect(cert.PublicKey).To(Equal(key.Public()))
+		Expect(chain).To(HaveLen(1))
+		Expect(chain[0].Subject.CommonName).To(Equal("Vault Intermediate CA"))
+
+		Expect(requests["sign/web"]["common_name"]).To(Equal("api"))
+		Expect(requests["sign/web"]["alt_names"]).To(Equal("api.payments.internal"))
+		Expect(requests["sign/web"]["ttl"]).To(Equal("3600s"))
+	})
+
+	It("should sign the CSR verbatim", func() {
+		config.CAName = "legacy"
+
+		_, _, err := signer.Sign(context.Background(), "legacy", csr, config)
+		Expect(err).To(BeNil())
+
+		Expect(requests["sign-verbatim/web"]["ext_key_usage"]).To(Equal([]interface{}{"ServerAuth"}))
+		Expect(requests["sign-verbatim/web"]).ToNot(HaveKey("common_name"))
+	})
+
+	It("should return the CA chain", func() {
+		chain, err := signer.LoadCertificates(context.Background(), "payments")
+		Expect(err).To(BeNil())
+		Expect(chain).To(HaveLen(1))
+		Expect(chain[0].Raw).To(Equal(caCert.Raw))
+	})
+
+	It("should return Vault's errors", func() {
+		vault.tokens = map[string]int{}
+
+		_, _, err := signer.Sign(context.Background(), "payments", csr, config)
+		Expect(err.Error()).To(Equal("error signing certificate with Vault: error looking up Vault token: Vault returned status 403: permission denied"))
+	})
+})
@@ -0,0 +1,379 @@
+package backend_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"docker-secretprovider-pki/backend"
+	"docker-secretprovider-pki/driver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeVault serves a subset of Vault's HTTP API.
+type fakeVault struct {
+	mu sync.Mutex
+
+	// tokens maps valid tokens to their TTL in seconds.
+	tokens map[string]int
+
+	// secrets maps KV paths to secret data.
+	secrets map[string]map[string]string
+
+	roleID, secretID string
+	leaseDuration    int
+	failRenew        bool
+
+	logins, renewals int
+
+	// pki serves the PKI secrets engine mounted at `pki`.
+	pki func(path string, data map[string]interface{}) (int, interface{})
+}
+
+func newFakeVault() *fakeVault {
+	return &fakeVault{
+		tokens:        map[string]int{"root": 0},
+		secrets:       map[string]map[string]string{},
+		roleID:        "pki-role",
+		secretID:      "pki-secret",
+		leaseDuration: 3600,
+	}
+}
+
+func (v *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	respond := func(status int, body interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+
+	if path == "auth/approle/login" {
+		var login map[string]string
+		json.NewDecoder(r.Body).Decode(&login)
+
+		if login["role_id"] != v.roleID || login["secret_id"] != v.secretID {
+			respond(http.StatusBadRequest, map[string]interface{}{"errors": []string{"invalid role or secret ID"}})
+			return
+		}
+
+		v.logins++
+		token := fmt.Sprintf("approle-%d", v.logins)
+		v.tokens[token] = v.leaseDuration
+
+		respond(http.StatusOK, map[string]interface{}{"auth": map[string]interface{}{
+			"client_token": token, "lease_duration": v.leaseDuration, "renewable": true,
+		}})
+		return
+	}
+
+	token := r.Header.Get("X-Vault-Token")
+	ttl, valid := v.tokens[token]
+	if !valid {
+		respond(http.StatusForbidden, map[string]interface{}{"errors": []string{"permission denied"}})
+		return
+	}
+
+	switch {
+	case path == "auth/token/lookup-self":
+		respond(http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"ttl": ttl, "renewable": ttl > 0}})
+	case path == "auth/token/renew-self":
+		if v.failRenew {
+			respond(http.StatusBadRequest, map[string]interface{}{"errors": []string{"lease is not renewable"}})
+			return
+		}
+
+		v.renewals++
+		respond(http.StatusOK, map[string]interface{}{"auth": map[string]interface{}{
+			"client_token": token, "lease_duration": ttl, "renewable": true,
+		}})
+	case strings.HasPrefix(path, "secret/data/"):
+		data, exists := v.secrets[strings.TrimPrefix(path, "secret/data/")]
+		if !exists {
+			respond(http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+			return
+		}
+
+		respond(http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+			"data": data, "metadata": map[string]interface{}{"version": 1},
+		}})
+	case strings.HasPrefix(path, "pki/") && v.pki != nil:
+		var data map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&data)
+
+		respond(v.pki(strings.TrimPrefix(path, "pki/"), data))
+	case strings.HasPrefix(path, "kv/"):
+		data, exists := v.secrets[strings.TrimPrefix(path, "kv/")]
+		if !exists {
+			respond(http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+			return
+		}
+
+		respond(http.StatusOK, map[string]interface{}{"data": data})
+	default:
+		respond(http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+	}
+}
+
+var _ = Describe("Vault backend", func() {
+	var (
+		vault  *fakeVault
+		server *httptest.Server
+		certPEM,
+		keyPEM []byte
+	)
+
+	var backends []*backend.VaultBackend
+
+	BeforeEach(func() {
+		vault = newFakeVault()
+		server = httptest.NewServer(vault)
+
+		certPEM, keyPEM = generateCA("Production CA")
+		vault.secrets["pki/production"] = map[string]string{"cert": string(certPEM), "key": string(keyPEM)}
+	})
+
+	AfterEach(func() {
+		for _, b := range backends {
+			b.Close()
+		}
+		backends = nil
+
+		server.Close()
+	})
+
+	newBackend := func(config backend.VaultConfig) *backend.VaultBackend {
+		config.Address = server.URL
+		b, err := backend.NewVaultBackend(config)
+		Expect(err).To(BeNil())
+		backends = append(backends, b)
+		return b
+	}
+
+	It("should load CAs from KV v2", func() {
+		b := newBackend(backend.VaultConfig{Auth: backend.VaultAuthConfig{Method: "token", Token: "root"}})
+
+		cert, err := b.Load(context.Background(), "production")
+		Expect(err).To(BeNil())
+		Expect(cert.Chain).To(HaveLen(1))
+		Expect(cert.Signer).ToNot(BeNil())
+
+		certs, err := b.LoadCertificates(context.Background(), "production")
+		Expect(err).To(BeNil())
+		Expect(certs[0].Subject.CommonName).To(Equal("Production CA"))
+	})
+
+	It("should load CAs from KV v1", func() {
+		b := newBackend(backend.VaultConfig{
+			Path:      "kv/pki/{{.CA}}",
+			KVVersion: 1,
+			Auth:      backend.VaultAuthConfig{Method: "token", Token: "root"},
+		})
+
+		cert, err := b.Load(context.Background(), "production")
+		Expect(err).To(BeNil())
+		Expect(cert.Chain).To(HaveLen(1))
+	})
+
+	It("should return an error for unknown CAs", func() {
+		b := newBackend(backend.VaultConfig{Auth: backend.VaultAuthConfig{Method: "token", Token: "root"}})
+
+		_, err := b.Load(context.Background(), "staging")
+		Expect(err.Error()).To(Equal("error fetching CA from Vault: Vault path not found: secret/data/pki/staging"))
+		Expect(driver.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("should not mistake missing auth paths for unknown CAs", func() {
+		b := newBackend(backend.VaultConfig{Auth: backend.VaultAuthConfig{Method: "approle", Mount: "ldap", RoleID: "pki-role", SecretID: "pki-secret"}})
+
+		_, err := b.Load(context.Background(), "production")
+		Expect(err).ToNot(BeNil())
+		Expect(driver.IsNotFound(err)).To(BeFalse())
+	})
+
+	It("should refuse CA names escaping the path", func() {
+		b := newBackend(backend.VaultConfig{Auth: backend.VaultAuthConfig{Method: "token", Token: "root"}})
+
+		_, err := b.Load(context.Background(), "../../sys/policy")
+		Expect(err.Error()).To(Equal("invalid CA name: ../../sys/policy"))
+	})
+
+	It("should return Vault's errors", func() {
+		b := newBackend(backend.VaultConfig{Auth: backend.VaultAuthConfig{Method: "token", Token: "revoked"}})
+
+		_, err := b.Load(context.Background(), "production")
+		Expect(err.Error()).To(Equal("error fetching CA from Vault: error looking up Vault token: Vault returned status 403: permission denied"))
+	})
+
+	Context("AppRole authentication", func() {
+		It("should log in once", func() {
+			b := newBackend(backend.VaultConfig{Auth: backend.VaultAuthConfig{Method: "approle", RoleID: "pki-role", SecretID: "pki-secret"}})
+
+			for i := 0; i < 3; i++ {
+				_, err := b.Load(context.Background(), "production")
+				Expect(err).To(BeNil())
+			}
+
+			Expect(vault.logins).To(Equal(1))
+			Expect(vault.renewals).To(Equal(0))
+		})
+
+		It("should renew the token's lease", func() {
+			vault.leaseDuration = 1
+			b := newBackend(backend.VaultConfig{Auth: backend.VaultAuthConfig{Method: "approle", RoleID: "pki-role", SecretID: "pki-secret"}})
+
+			_, err := b.Load(context.Background(), "production")
+			Expect(err).To(BeNil())
+
+			time.Sleep(800 * time.Millisecond)
+
+			_, err = b.Load(context.Background(), "production")
+			Expect(err).To(BeNil())
+			Expect(vault.logins).To(Equal(1))
+			Expect(vault.renewals).To(Equal(1))
+		})
+
+		It("should renew the token's lease without requests", func() {
+			vault.leaseDuration = 3
+			b := newBackend(backend.VaultConfig{Auth: backend.VaultAuthConfig{Method: "approle", RoleID: "pki-role", SecretID: "pki-secret"}})
+
+			_, err := b.Load(context.Background(), "production")
+			Expect(err).To(BeNil())
+
+			Eventually(func() int {
+				vault.mu.Lock()
+				defer vault.mu.Unlock()
+				return vault.renewals
+			}, 3*time.Second, 100*time.Millisecond).Should(BeNumerically(">=", 1))
+			Expect(vault.logins).To(Equal(1))
+		})
+
+		It("should stop renewing the token when closed", func() {
+			vault.leaseDuration = 3
+			b := newBackend(backend.VaultConfig{Auth: backend.VaultAuthConfig{Method: "approle", RoleID: "pki-role", SecretID: "pki-secret"}})
+
+			_, err := b.Load(context.Background(), "production")
+			Expect(err).To(BeNil())
+			Expect(b.Close()).To(Succeed())
+
+			Consistently(func() int {
+				vault.mu.Lock()
+				defer vault.mu.Unlock()
+				return vault.renewals
+			}, 3*time.Second, 100*time.Millisecond).Should(BeZero())
+		})
+
+		It("should log in again when the lease can not be renewed", func() {
+			vault.leaseDuration = 1
+			vault.failRenew = true
+			b := newBackend(backend.VaultConfig{Auth: backend.VaultAuthConfig{Method: "approle", RoleID: "pki-role", SecretID: "pki-secret"}})
+
+			_, err := b.Load(context.Background(), "production")
+			Expect(err).To(BeNil())
+
+			time.Sleep(800 * time.Millisecond)
+
+			_, err = b.Load(context.Background(), "production")
+			Expect(err).To(BeNil())
+			Expect(vault.logins).To(Equal(2))
+		})
+	})
+
+	Context("File authentication", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "vault")
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("should use the current token of the file", func() {
+			vault.tokens["agent-1"] = 60
+
+			config := filepath.Join(dir, backend.VaultFile)
+			Expect(ioutil.WriteFile(config, []byte(fmt.Sprintf(
+				`{"address": %q, "auth": {"method": "file", "token_file": "token"}}`, server.URL,
+			)), 0600)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "token"), []byte("agent-1\n"), 0600)).To(Succeed())
+
+			vaultConfig, err := backend.LoadVaultConfig(config)
+			Expect(err).To(BeNil())
+
+			b, err := backend.NewVaultBackend(vaultConfig)
+			Expect(err).To(BeNil())
+
+			_, err = b.Load(context.Background(), "production")
+			Expect(err).To(BeNil())
+
+			// Tokens are rotated by e.g. Vault Agent.
+			delete(vault.tokens, "agent-1")
+			vault.tokens["agent-2"] = 60
+			Expect(ioutil.WriteFile(filepath.Join(dir, "token"), []byte("agent-2\n"), 0600)).To(Succeed())
+
+			_, err = b.Load(context.Background(), "production")
+			Expect(err).To(BeNil())
+		})
+	})
+
+	Context("Token authentication", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "vault")
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("should read the token file again when the token can not be renewed", func() {
+			vault.tokens["token-1"] = 1
+			vault.failRenew = true
+
+			tokenFile := filepath.Join(dir, "token")
+			Expect(ioutil.WriteFile(tokenFile, []byte("token-1\n"), 0600)).To(Succeed())
+
+			b := newBackend(backend.VaultConfig{Auth: backend.VaultAuthConfig{Method: "token", TokenFile: tokenFile}})
+
+			_, err := b.Load(context.Background(), "production")
+			Expect(err).To(BeNil())
+
+			vault.mu.Lock()
+			delete(vault.tokens, "token-1")
+			vault.tokens["token-2"] = 60
+			vault.mu.Unlock()
+			Expect(ioutil.WriteFile(tokenFile, []byte("token-2\n"), 0600)).To(Succeed())
+
+			time.Sleep(1100 * time.Millisecond)
+
+			_, err = b.Load(context.Background(), "production")
+			Expect(err).To(BeNil())
+		})
+	})
+
+	It("should validate authentication configuration", func() {
+		_, err := backend.NewVaultBackend(backend.VaultConfig{Address: server.URL, Auth: backend.VaultAuthConfig{Method: "ldap"}})
+		Expect(err.Error()).To(Equal("unknown Vault authentication method: ldap"))
+	})
+})
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"docker-secretprovider-pki/backend"
+	"docker-secretprovider-pki/driver"
+)
+
+// fileSettings configures the file and encrypted file backends.
+type fileSettings struct {
+	Dir            string `json:"dir"`
+	PassphraseFile string `json:"passphrase_file"`
+}
+
+// managedSettings configures the managed backend.
+type managedSettings struct {
+	Dir string `json:"dir"`
+	backend.ManagedConfig
+}
+
+// newBackend creates a CA backend of the kind. Backends are configured by
+// the settings when given, or by their configuration files in the config
+// directory otherwise. Relative file names are resolved against the config
+// directory. The passphrase of CA_PASSPHRASE is passed to encrypted file
+// backends without a passphrase file.
+func newBackend(kind string, settings json.RawMessage, envPassphrase []byte) (driver.CABackend, error) {
+	switch kind {
+	case "file", "encrypted-file":
+		s := fileSettings{Dir: backend.DefaultFileDir}
+		if err := parseSettings(settings, &s); err != nil {
+			return nil, err
+		}
+
+		if kind == "file" {
+			return backend.NewFileBackend(configPath(s.Dir))
+		}
+
+		var passphrase []byte
+		var err error
+		if s.PassphraseFile != "" {
+			passphrase, err = readPassphraseFile(s.PassphraseFile)
+		} else {
+			passphrase, err = caPassphrase(envPassphrase)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return backend.NewEncryptedFileBackend(configPath(s.Dir), passphrase)
+	case "vault":
+		var config backend.VaultConfig
+		var err error
+		if settings == nil {
+			config, err = backend.LoadVaultConfig(configPath(backend.VaultFile))
+		} else {
+			config, err = backend.ParseVaultConfig(settings, driver.DefaultConfigDir)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return backend.NewVaultBackend(config)
+	case "pkcs11":
+		return newPKCS11Backend(settings)
+	case "managed":
+		s := managedSettings{Dir: backend.DefaultManagedDir}
+		if settings == nil {
+			config, err := loadManagedConfig()
+			if err != nil {
+				return nil, err
+			}
+
+			s.ManagedConfig = config
+		} else if err := parseSettings(settings, &s); err != nil {
+			return nil, err
+		}
+
+		return backend.NewManagedBackend(configPath(s.Dir), s.ManagedConfig)
+	case "secrethub":
+		return backend.NewSecrethubBackend()
+	case "test":
+		var config backend.TestConfig
+		var err error
+		if settings == nil {
+			config, err = loadTestConfig()
+		} else {
+			config, err = backend.ParseTestConfig(settings)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return backend.NewTestBackend(config)
+	case "routing":
+		config, err := backend.LoadRoutingConfig(configPath(backend.RoutingFile))
+		if err != nil {
+			return nil, err
+		}
+
+		return backend.NewRoutingBackend(config, func(kind string, settings json.RawMessage) (driver.CABackend, error) {
+			if kind == "routing" {
+				return nil, errors.New("routing backends can not be nested")
+			}
+
+			return newBackend(kind, settings, envPassphrase)
+		})
+	case "":
+		return nil, errors.New("backend not configured. Use `docker plugin set <plugin alias> BACKEND=<value>`")
+	default:
+		return nil, errors.Errorf("unknown backend: %s", kind)
+	}
+}
+
+// newCachingBackend wraps the backend in a cache when CA_CACHE_TTL is set.
+// Cached CAs are invalidated on SIGHUP, e.g. after rotating a CA.
+func newCachingBackend(ca driver.CABackend) (driver.CABackend, error) {
+	if os.Getenv("CA_CACHE_TTL") == "" {
+		return ca, nil
+	}
+
+	config := backend.CacheConfig{}
+	for env, ttl := range map[string]*time.Duration{
+		"CA_CACHE_TTL":           &config.TTL,
+		"CA_CACHE_NOT_FOUND_TTL": &config.NotFoundTTL,
+	} {
+		if value := os.Getenv(env); value != "" {
+			var err error
+			if *ttl, err = time.ParseDuration(value); err != nil {
+				return nil, errors.Wrapf(err, "invalid %s", env)
+			}
+		}
+	}
+
+	cache, err := backend.NewCachingBackend(ca, config)
+	if err != nil {
+		return nil, err
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			cache.InvalidateAll()
+			zap.S().Info("pki: invalidated cached CAs")
+		}
+	}()
+
+	return cache, nil
+}
+
+// parseSettings decodes the backend's settings, if any.
+func parseSettings(settings json.RawMessage, v interface{}) error {
+	if settings == nil {
+		return nil
+	}
+
+	return errors.Wrap(json.Unmarshal(settings, v), "error parsing backend settings")
+}
+
+// loadManagedConfig reads the managed backend's optional configuration file.
+func loadManagedConfig() (backend.ManagedConfig, error) {
+	filename := configPath(backend.ManagedFile)
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return backend.ManagedConfig{}, nil
+	}
+
+	return backend.LoadManagedConfig(filename)
+}
+
+// loadTestConfig reads the test backend's optional configuration file.
+func loadTestConfig() (backend.TestConfig, error) {
+	filename := configPath(backend.TestFile)
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return backend.TestConfig{}, nil
+	}
+
+	return backend.LoadTestConfig(filename)
+}
+
+// configPath resolves a file name relative to the config directory.
+func configPath(filename string) string {
+	if filepath.IsAbs(filename) {
+		return filename
+	}
+
+	return filepath.Join(driver.DefaultConfigDir, filename)
+}
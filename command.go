@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"docker-secretprovider-pki/approval"
+	"docker-secretprovider-pki/backend"
+	"docker-secretprovider-pki/driver"
+)
+
+// runCommand runs an administrative command given on the command line.
+func runCommand(args []string) error {
+	switch args[0] {
+	case "approval":
+		return runApproval(args[1:])
+	case "encrypt-key":
+		return runEncryptKey(args[1:])
+	default:
+		return errors.Errorf("unknown command: %s", args[0])
+	}
+}
+
+// runApproval lists, approves and denies certificate requests pending
+// approval, e.g. `approval deny -reason "not needed" <id>`.
+func runApproval(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: approval list|approve|deny [flags] [id]")
+	}
+
+	action := args[0]
+
+	flags := flag.NewFlagSet("approval "+action, flag.ContinueOnError)
+	configDir := flags.String("config-dir", driver.DefaultConfigDir, "plugin's configuration directory")
+	reason := flags.String("reason", "", "reason of the decision")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	store := approval.NewStore(filepath.Join(*configDir, approval.DefaultDir))
+
+	switch action {
+	case "list":
+		records, err := store.List()
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSTATUS\tCA\tSERVICE\tSECRET\tCN\tDNS NAMES\tREQUESTED")
+		for _, r := range records {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				r.ID, r.Status, r.CA, r.Service, r.Secret, r.CommonName,
+				strings.Join(r.DNSNames, ","), r.RequestedAt.Format(time.RFC3339))
+		}
+
+		return w.Flush()
+	case "approve", "deny":
+		if flags.NArg() != 1 {
+			return errors.Errorf("usage: approval %s [flags] <id>", action)
+		}
+
+		status := approval.StatusApproved
+		if action == "deny" {
+			status = approval.StatusDenied
+		}
+
+		record, err := store.Decide(flags.Arg(0), status, *reason)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("request %s for %s of service %s %s\n", record.ID, record.CommonName, record.Service, record.Status)
+		return nil
+	default:
+		return errors.Errorf("unknown approval action: %s", action)
+	}
+}
+
+// runEncryptKey encrypts a CA private key for the encrypted file backend,
+// e.g. `encrypt-key -passphrase-file passphrase key.pem encrypted.pem`.
+// Without a passphrase file, the passphrase is read from CA_PASSPHRASE.
+func runEncryptKey(args []string) error {
+	flags := flag.NewFlagSet("encrypt-key", flag.ContinueOnError)
+	passphraseFile := flags.String("passphrase-file", "", "file holding the passphrase")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 2 {
+		return errors.New("usage: encrypt-key [flags] <key> <encrypted key>")
+	}
+
+	var passphrase []byte
+	if *passphraseFile != "" {
+		raw, err := ioutil.ReadFile(*passphraseFile)
+		if err != nil {
+			return errors.Wrap(err, "error reading passphrase file")
+		}
+
+		passphrase = []byte(strings.TrimRight(string(raw), "\r\n"))
+	} else {
+		passphrase = []byte(os.Getenv("CA_PASSPHRASE"))
+	}
+
+	key, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		return errors.Wrap(err, "error reading private key")
+	}
+
+	encrypted, err := backend.EncryptPrivateKey(key, passphrase)
+	if err != nil {
+		return err
+	}
+
+	return errors.Wrap(ioutil.WriteFile(flags.Arg(1), encrypted, 0600), "error writing encrypted private key")
+}
@@ -0,0 +1,49 @@
+package driver
+
+import (
+	"github.com/docker/go-plugins-helpers/secrets"
+	"go.uber.org/zap"
+)
+
+// auditLog returns the logger for audit records.
+func auditLog() *zap.Logger {
+	return zap.L().Named("audit")
+}
+
+// auditIssued records an issued certificate in the audit log.
+func auditIssued(request secrets.Request, config CertRequest, issued *IssuedCertificate) {
+	auditLog().Info("pki: certificate issued",
+		zap.String("secret", request.SecretName),
+		zap.String("service", request.ServiceName),
+		zap.String("task", request.TaskName),
+		zap.String("group", config.Group),
+		zap.String("ca", config.CAName),
+		zap.String("cn", issued.Certificate.Subject.CommonName),
+		zap.String("serial", issued.Certificate.SerialNumber.Text(16)),
+		zap.Time("not_after", issued.Certificate.NotAfter),
+		zap.String("format", config.Format),
+		zap.String("passphrase_source", config.PassphraseSource),
+	)
+}
+
+// auditTrust records a trust bundle handed out in the audit log.
+func auditTrust(request secrets.Request, config CertRequest) {
+	auditLog().Info("pki: trust bundle issued",
+		zap.String("secret", request.SecretName),
+		zap.String("service", request.ServiceName),
+		zap.String("task", request.TaskName),
+		zap.Strings("ca", config.TrustedCAs),
+	)
+}
+
+// auditDenied records a denied certificate request in the audit log.
+func auditDenied(request secrets.Request, config CertRequest, reason error) {
+	auditLog().Warn("pki: certificate request denied",
+		zap.String("secret", request.SecretName),
+		zap.String("service", request.ServiceName),
+		zap.String("task", request.TaskName),
+		zap.String("ca", config.CAName),
+		zap.String("cn", config.CommonName),
+		zap.String("reason", reason.Error()),
+	)
+}
@@ -0,0 +1,96 @@
+package driver_test
+
+import (
+	"context"
+	"crypto/x509"
+
+	"github.com/docker/go-plugins-helpers/secrets"
+	"github.com/pkg/errors"
+
+	"docker-secretprovider-pki/backend"
+	"docker-secretprovider-pki/driver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// authorizerFunc allows using ordinary functions as authorizers.
+type authorizerFunc func(request secrets.Request, config driver.CertRequest) (driver.CertRequest, error)
+
+func (f authorizerFunc) Authorize(ctx context.Context, request secrets.Request, config driver.CertRequest) (driver.CertRequest, error) {
+	return f(request, config)
+}
+
+var _ = Describe("External authorizer", func() {
+	var docker *fakeDocker
+
+	BeforeEach(func() {
+		docker = &fakeDocker{secrets: map[string]map[string]string{
+			"web": {
+				"pki.ca":        "test",
+				"pki.cn":        "web",
+				"pki.usage":     "server",
+				"pki.dns_names": "web.internal",
+			},
+		}}
+	})
+
+	When("Authorizer denies the request", func() {
+		It("should return the denial", func() {
+			drv, err := driver.NewDriver(&backend.TestBackend{}, docker, driver.WithAuthorizer(authorizerFunc(
+				func(request secrets.Request, config driver.CertRequest) (driver.CertRequest, error) {
+					return config, errors.New("denied by authorizer of CA test")
+				},
+			)))
+			Expect(err).To(BeNil())
+
+			response := drv.Get(secrets.Request{SecretName: "web"})
+			Expect(response.Err).To(Equal("pki: certificate request denied: denied by authorizer of CA test"))
+			Expect(response.Value).To(BeEmpty())
+		})
+	})
+
+	When("Authorizer modifies the request", func() {
+		It("should issue the modified request", func() {
+			drv, err := driver.NewDriver(&backend.TestBackend{}, docker, driver.WithAuthorizer(authorizerFunc(
+				func(request secrets.Request, config driver.CertRequest) (driver.CertRequest, error) {
+					config.DNSNames = []string{"web.approved.internal"}
+					return config, nil
+				},
+			)))
+			Expect(err).To(BeNil())
+
+			response := drv.Get(secrets.Request{SecretName: "web"})
+			Expect(response.Err).To(BeEmpty())
+
+			bundle, err := parsePKIBundle(response.Value)
+			Expect(err).To(BeNil())
+
+			cert, err := x509.ParseCertificate(bundle.Certificate[0])
+			Expect(err).To(BeNil())
+			Expect(cert.DNSNames).To(Equal([]string{"web.approved.internal"}))
+		})
+
+		It("should check the modified request against the policy", func() {
+			drv, err := driver.NewDriver(&backend.TestBackend{}, docker,
+				driver.WithPolicy(policyFunc(func(request secrets.Request, config driver.CertRequest) error {
+					if config.DNSNames[0] != "web.internal" {
+						return errors.Errorf("DNS name %q is not allowed for CA test", config.DNSNames[0])
+					}
+					return nil
+				})),
+				driver.WithAuthorizer(authorizerFunc(
+					func(request secrets.Request, config driver.CertRequest) (driver.CertRequest, error) {
+						config.DNSNames = []string{"admin.internal"}
+						return config, nil
+					},
+				)),
+			)
+			Expect(err).To(BeNil())
+
+			response := drv.Get(secrets.Request{SecretName: "web"})
+			Expect(response.Err).To(Equal(`pki: certificate request denied: request modified by authorizer: DNS name "admin.internal" is not allowed for CA test`))
+			Expect(response.Value).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,189 @@
+package driver
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/secrets"
+	"github.com/pkg/errors"
+)
+
+// CA is a certificate authority loaded from a CA backend.
+type CA struct {
+	// Chain is the CA's certificate chain, starting with the certificate
+	// issued certificates are signed by.
+	Chain []*x509.Certificate
+
+	// Signer signs certificates with the CA's private key. The key may be
+	// held outside of the plugin's memory, e.g. in an HSM.
+	Signer crypto.Signer
+
+	Config CAConfig
+}
+
+// CAConfig holds the settings of a CA kept along with it in the backend.
+// Unset settings fall back to the driver's defaults.
+type CAConfig struct {
+	// TrustDomain is the SPIFFE trust domain of the CA, overriding the one
+	// configured in the CA certificate.
+	TrustDomain string
+
+	// Lifetime is the lifetime of certificates not requesting one.
+	Lifetime time.Duration
+
+	// KeyType and KeySize specify the key of certificates not requesting
+	// a key type or size.
+	KeyType string
+	KeySize int
+
+	// Policy is checked against certificate requests of the CA in addition
+	// to the driver's policy.
+	Policy Policy
+}
+
+// NewCA creates a CA from a TLS certificate holding the CA's chain and
+// private key.
+func NewCA(cert *tls.Certificate, config CAConfig) (*CA, error) {
+	ca := &CA{Config: config}
+
+	for _, raw := range cert.Certificate {
+		c, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing CA certificate")
+		}
+
+		ca.Chain = append(ca.Chain, c)
+	}
+
+	signer, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.Errorf("CA private key of type %T can not be used for signing", cert.PrivateKey)
+	}
+
+	ca.Signer = signer
+
+	if err := ca.Validate(); err != nil {
+		return nil, err
+	}
+
+	return ca, nil
+}
+
+// Validate checks that the CA has certificates and that its private key
+// belongs to the certificate issued certificates are signed by.
+func (ca *CA) Validate() error {
+	if len(ca.Chain) == 0 {
+		return errors.New("no CA certificates found")
+	}
+
+	if !publicKeysEqual(ca.Signer.Public(), ca.Certificate().PublicKey) {
+		return errors.Errorf("CA private key does not match CA certificate: %s", ca.Certificate().Subject.CommonName)
+	}
+
+	return nil
+}
+
+// Certificate returns the certificate issued certificates are signed by.
+func (ca *CA) Certificate() *x509.Certificate {
+	return ca.Chain[0]
+}
+
+// TrustDomain returns the SPIFFE trust domain of the CA.
+func (ca *CA) TrustDomain() string {
+	if ca.Config.TrustDomain != "" {
+		return ca.Config.TrustDomain
+	}
+
+	return caTrustDomain(ca.Certificate())
+}
+
+// applyDefaults replaces the driver's defaults in the request with the
+// CA's defaults. Settings requested explicitly are kept.
+func (c CAConfig) applyDefaults(config *CertRequest) error {
+	if config.defaultLifetime && c.Lifetime > 0 {
+		config.Lifetime = c.Lifetime
+	}
+
+	if config.defaultKey && (c.KeyType != "" || c.KeySize != 0) {
+		keyType, keySize, err := NormalizeKeyParams(c.KeyType, c.KeySize)
+		if err != nil {
+			return errors.Wrapf(err, "invalid default key of CA %s", config.CAName)
+		}
+
+		config.KeyType = keyType
+		config.KeySize = keySize
+	}
+
+	config.defaultLifetime = false
+	config.defaultKey = false
+
+	return nil
+}
+
+// check verifies the request against the CA's policy.
+func (c CAConfig) check(request secrets.Request, config CertRequest) error {
+	if c.Policy == nil {
+		return nil
+	}
+
+	return c.Policy.Check(request, config)
+}
+
+// loadCA loads the CA signing certificates locally. CAs signed by the
+// signing backend are not loaded and nil is returned for them.
+func (d Driver) loadCA(ctx context.Context, name string) (*CA, error) {
+	if d.signer != nil && d.signer.Signs(name) {
+		return nil, nil
+	}
+
+	ca, err := d.ca.Load(ctx, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "error loading CA bundle")
+	}
+
+	if len(ca.Chain) == 0 {
+		return nil, errors.Errorf("CA has no certificates: %s", name)
+	}
+
+	if ca.Signer == nil {
+		return nil, errors.Errorf("CA %s can not be used for signing", name)
+	}
+
+	return ca, nil
+}
+
+// NotFound marks an error of a CA backend as the CA not existing, keeping
+// the error's message.
+func NotFound(err error) error {
+	return notFoundError{err}
+}
+
+// IsNotFound reports whether the error, or an error it wraps, was marked as
+// the CA not existing.
+func IsNotFound(err error) bool {
+	for err != nil {
+		if _, ok := err.(notFoundError); ok {
+			return true
+		}
+
+		cause, ok := err.(interface{ Cause() error })
+		if !ok {
+			return false
+		}
+
+		err = cause.Cause()
+	}
+
+	return false
+}
+
+type notFoundError struct {
+	error
+}
+
+func (e notFoundError) Cause() error {
+	return e.error
+}
@@ -0,0 +1,166 @@
+package driver_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/secrets"
+	"github.com/pkg/errors"
+
+	"docker-secretprovider-pki/driver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CA configuration", func() {
+	var (
+		ca     *generatedCA
+		docker *fakeDocker
+		drv    *driver.Driver
+	)
+
+	BeforeEach(func() {
+		var err error
+		ca, err = newGeneratedCA(mustGenerateKey())
+		Expect(err).To(BeNil())
+
+		docker = &fakeDocker{secrets: map[string]map[string]string{
+			"web": {
+				"pki.ca":    "internal",
+				"pki.cn":    "web",
+				"pki.usage": "server",
+			},
+		}}
+
+		drv, err = driver.NewDriver(ca, docker)
+		Expect(err).To(BeNil())
+	})
+
+	issue := func() (*x509.Certificate, interface{}) {
+		response := drv.Get(secrets.Request{SecretName: "web"})
+		Expect(response.Err).To(BeEmpty())
+
+		bundle, err := parsePKIBundle(response.Value)
+		Expect(err).To(BeNil())
+
+		leaf, err := x509.ParseCertificate(bundle.Certificate[0])
+		Expect(err).To(BeNil())
+
+		return leaf, bundle.PrivateKey
+	}
+
+	When("CA has defaults", func() {
+		BeforeEach(func() {
+			ca.ca.Config = driver.CAConfig{
+				Lifetime: 72 * time.Hour,
+				KeyType:  driver.KeyTypeECDSA,
+			}
+		})
+
+		It("should issue certificates not requesting settings with CA's defaults", func() {
+			leaf, key := issue()
+			Expect(leaf.NotAfter.Sub(leaf.NotBefore)).To(Equal(72 * time.Hour))
+			Expect(key).To(BeAssignableToTypeOf(&ecdsa.PrivateKey{}))
+		})
+
+		It("should keep settings requested explicitly", func() {
+			docker.secrets["web"]["pki.lifetime"] = "1h"
+			docker.secrets["web"]["pki.key_type"] = driver.KeyTypeRSA
+
+			leaf, key := issue()
+			Expect(leaf.NotAfter.Sub(leaf.NotBefore)).To(Equal(time.Hour))
+			Expect(key).ToNot(BeAssignableToTypeOf(&ecdsa.PrivateKey{}))
+		})
+
+		It("should keep the lifetime chosen by the authorizer", func() {
+			var err error
+			drv, err = driver.NewDriver(ca, docker, driver.WithAuthorizer(authorizerFunc(
+				func(request secrets.Request, config driver.CertRequest) (driver.CertRequest, error) {
+					config.Lifetime = time.Hour
+					return config, nil
+				},
+			)))
+			Expect(err).To(BeNil())
+
+			leaf, key := issue()
+			Expect(leaf.NotAfter.Sub(leaf.NotBefore)).To(Equal(time.Hour))
+			Expect(key).To(BeAssignableToTypeOf(&ecdsa.PrivateKey{}))
+		})
+	})
+
+	When("CA has a trust domain", func() {
+		It("should issue SPIFFE IDs of the trust domain", func() {
+			ca.ca.Config.TrustDomain = "cluster.internal"
+			docker.secrets["web"]["pki.spiffe_id"] = "spiffe://cluster.internal/web"
+
+			leaf, _ := issue()
+			Expect(leaf.URIs[0].String()).To(Equal("spiffe://cluster.internal/web"))
+		})
+
+		It("should refuse SPIFFE IDs of other trust domains", func() {
+			ca.ca.Config.TrustDomain = "cluster.internal"
+			docker.secrets["web"]["pki.spiffe_id"] = "spiffe://other.internal/web"
+
+			response := drv.Get(secrets.Request{SecretName: "web"})
+			Expect(response.Err).To(ContainSubstring("SPIFFE ID trust domain other.internal does not match CA's trust domain cluster.internal"))
+		})
+	})
+
+	When("CA has a policy", func() {
+		It("should check requests against it", func() {
+			ca.ca.Config.Policy = policyFunc(func(request secrets.Request, config driver.CertRequest) error {
+				return errors.Errorf("lifetime %s exceeds allowed maximum for CA %s", config.Lifetime, config.CAName)
+			})
+
+			response := drv.Get(secrets.Request{SecretName: "web"})
+			Expect(response.Err).To(Equal("pki: certificate request denied: lifetime 24h0m0s exceeds allowed maximum for CA internal"))
+		})
+
+		It("should check the request with CA's defaults applied", func() {
+			var checked driver.CertRequest
+			ca.ca.Config.Lifetime = 72 * time.Hour
+			ca.ca.Config.Policy = policyFunc(func(request secrets.Request, config driver.CertRequest) error {
+				checked = config
+				return nil
+			})
+
+			issue()
+			Expect(checked.Lifetime).To(Equal(72 * time.Hour))
+		})
+	})
+})
+
+var _ = Describe("CA key pairs", func() {
+	It("should refuse private keys not belonging to the CA certificate", func() {
+		ca, err := newGeneratedCA(mustGenerateKey())
+		Expect(err).To(BeNil())
+
+		_, err = driver.NewCA(&tls.Certificate{Certificate: [][]byte{ca.cert.Raw}, PrivateKey: mustGenerateKey()}, driver.CAConfig{})
+		Expect(err).To(MatchError("CA private key does not match CA certificate: Generated Test Authority"))
+	})
+
+	It("should accept matching key pairs", func() {
+		key := mustGenerateKey()
+		ca, err := newGeneratedCA(key)
+		Expect(err).To(BeNil())
+
+		_, err = driver.NewCA(&tls.Certificate{Certificate: [][]byte{ca.cert.Raw}, PrivateKey: key}, driver.CAConfig{})
+		Expect(err).To(BeNil())
+	})
+})
+
+var _ = Describe("CA not found errors", func() {
+	It("should be recognized when wrapped", func() {
+		err := errors.Wrap(driver.NotFound(errors.New("CA not found: test")), "error loading CA bundle")
+		Expect(err).To(MatchError("error loading CA bundle: CA not found: test"))
+		Expect(driver.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("should not be confused with other errors", func() {
+		Expect(driver.IsNotFound(errors.Wrap(errors.New("backend unavailable"), "error loading CA bundle"))).To(BeFalse())
+		Expect(driver.IsNotFound(nil)).To(BeFalse())
+	})
+})
@@ -0,0 +1,102 @@
+package driver
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// issueDelegated creates a new TLS certificate signed by the signing
+// backend. The private key is generated locally and only the CSR is sent
+// to the backend.
+func (d Driver) issueDelegated(ctx context.Context, config CertRequest) (*IssuedCertificate, error) {
+	if config.SignatureAlgorithm != x509.UnknownSignatureAlgorithm {
+		return nil, errors.Errorf("signature algorithm can not be requested for CA %s, which is signed by a signing backend", config.CAName)
+	}
+
+	// Signing backends don't copy extensions from the CSR, e.g. Vault drops
+	// them, so the certificate would silently lack the workload's identity.
+	if len(config.Extensions) > 0 {
+		return nil, errors.Errorf("certificate extensions can not be added to certificates of CA %s, which is signed by a signing backend", config.CAName)
+	}
+
+	if config.SPIFFEID != nil {
+		if err := d.validateDelegatedTrustDomain(ctx, config); err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := d.generateKey(config.KeyType, config.KeySize)
+	if err != nil {
+		return nil, errors.Wrap(err, "error generating private key")
+	}
+
+	template := &x509.CertificateRequest{
+		Subject:         pkix.Name{CommonName: config.CommonName},
+		DNSNames:        config.DNSNames,
+		IPAddresses:     config.IPAddrs,
+		URIs:            config.URIs,
+		ExtraExtensions: config.Extensions,
+	}
+
+	if config.SPIFFEID != nil {
+		template.URIs = []*url.URL{config.SPIFFEID}
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating certificate signing request")
+	}
+
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing certificate signing request")
+	}
+
+	cert, chain, err := d.signer.Sign(ctx, config.CAName, csr, config)
+	if err != nil {
+		return nil, errors.Wrap(err, "error signing certificate")
+	}
+
+	if !publicKeysEqual(cert.PublicKey, key.Public()) {
+		return nil, errors.New("signed certificate does not match the generated private key")
+	}
+
+	return &IssuedCertificate{
+		PrivateKey:  key,
+		Certificate: cert,
+		Chain:       chain,
+	}, nil
+}
+
+// validateDelegatedTrustDomain checks the request's SPIFFE ID against the
+// trust domain of the CA certificate loaded from the signing backend.
+func (d Driver) validateDelegatedTrustDomain(ctx context.Context, config CertRequest) error {
+	trust, ok := d.signer.(TrustBackend)
+	if !ok {
+		return errors.Errorf("SPIFFE IDs can not be issued by CA %s, whose certificates the signing backend doesn't provide", config.CAName)
+	}
+
+	chain, err := trust.LoadCertificates(ctx, config.CAName)
+	if err != nil {
+		return errors.Wrap(err, "error loading CA certificates")
+	}
+
+	if len(chain) == 0 {
+		return errors.Errorf("CA has no certificates: %s", config.CAName)
+	}
+
+	return validateTrustDomain(config.SPIFFEID, caTrustDomain(chain[0]))
+}
+
+// publicKeysEqual reports whether two public keys are the same.
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	key, ok := a.(interface{ Equal(x crypto.PublicKey) bool })
+
+	return ok && key.Equal(b)
+}
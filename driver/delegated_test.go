@@ -0,0 +1,170 @@
+package driver_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"docker-secretprovider-pki/backend"
+	"docker-secretprovider-pki/driver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeSigner signs CSRs of CA "remote" with a generated CA, like a remote
+// signing service would.
+type fakeSigner struct {
+	ca   *generatedCA
+	csrs []*x509.CertificateRequest
+}
+
+func (s *fakeSigner) Signs(name string) bool {
+	return name == "remote"
+}
+
+func (s *fakeSigner) Sign(ctx context.Context, name string, csr *x509.CertificateRequest, config driver.CertRequest) (*x509.Certificate, []*x509.Certificate, error) {
+	s.csrs = append(s.csrs, csr)
+
+	if err := csr.CheckSignature(); err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(config.Lifetime),
+		ExtKeyUsage:  config.Usage,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, s.ca.cert, csr.PublicKey, s.ca.ca.Signer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	return cert, []*x509.Certificate{s.ca.cert}, err
+}
+
+func (s *fakeSigner) LoadCertificates(ctx context.Context, name string) ([]*x509.Certificate, error) {
+	if name != "remote" {
+		return nil, errors.Errorf("unknown CA: %s", name)
+	}
+
+	return []*x509.Certificate{s.ca.cert}, nil
+}
+
+var _ = Describe("Signing backend", func() {
+	var (
+		signer *fakeSigner
+		drv    *driver.Driver
+		config driver.CertRequest
+	)
+
+	BeforeEach(func() {
+		ca, err := newGeneratedCA(mustGenerateKey(), func(template *x509.Certificate) {
+			template.Subject = pkix.Name{CommonName: "Remote CA"}
+		})
+		Expect(err).To(BeNil())
+
+		signer = &fakeSigner{ca: ca}
+
+		drv, err = driver.NewDriver(&backend.TestBackend{}, &fakeDocker{}, driver.WithSigningBackend(signer))
+		Expect(err).To(BeNil())
+
+		config = driver.CertRequest{
+			CAName:     "remote",
+			CommonName: "web",
+			DNSNames:   []string{"web.internal"},
+			Usage:      []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			Lifetime:   time.Hour,
+			KeyType:    driver.KeyTypeECDSA,
+			KeySize:    256,
+		}
+	})
+
+	It("should sign a CSR of a locally generated key", func() {
+		issued, err := drv.Issue(context.Background(), config)
+		Expect(err).To(BeNil())
+
+		Expect(signer.csrs).To(HaveLen(1))
+		Expect(signer.csrs[0].Subject.CommonName).To(Equal("web"))
+		Expect(signer.csrs[0].DNSNames).To(Equal([]string{"web.internal"}))
+
+		Expect(issued.Certificate.Issuer.CommonName).To(Equal("Remote CA"))
+		Expect(issued.Certificate.PublicKey).To(Equal(issued.PrivateKey.Public()))
+		Expect(issued.Chain).To(HaveLen(1))
+		Expect(issued.Chain[0].Subject.CommonName).To(Equal("Remote CA"))
+	})
+
+	It("should load other CAs from the CA backend", func() {
+		config.CAName = "test"
+
+		issued, err := drv.Issue(context.Background(), config)
+		Expect(err).To(BeNil())
+		Expect(signer.csrs).To(BeEmpty())
+		Expect(issued.Certificate.Issuer.CommonName).To(Equal("PKI Provider Test Intermediate CA test"))
+	})
+
+	It("should return trust bundles of the signing backend", func() {
+		bundle, err := drv.IssueTrustBundle(context.Background(), driver.CertRequest{TrustedCAs: []string{"remote"}})
+		Expect(err).To(BeNil())
+
+		parsed, err := parsePKIBundle(bundle)
+		Expect(err).To(BeNil())
+		Expect(parsed.Certificate).To(HaveLen(1))
+		Expect(parsed.Certificate[0]).To(Equal(signer.ca.cert.Raw))
+	})
+
+	It("should refuse certificate extensions the signing backend would drop", func() {
+		config.Extensions = []pkix.Extension{{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}, Value: []byte{0x05, 0x00}}}
+
+		_, err := drv.Issue(context.Background(), config)
+		Expect(err.Error()).To(Equal("certificate extensions can not be added to certificates of CA remote, which is signed by a signing backend"))
+		Expect(signer.csrs).To(BeEmpty())
+	})
+
+	Context("with SPIFFE IDs", func() {
+		BeforeEach(func() {
+			ca, err := newGeneratedCA(mustGenerateKey(), func(template *x509.Certificate) {
+				template.Subject = pkix.Name{CommonName: "Remote CA"}
+				template.URIs = []*url.URL{{Scheme: "spiffe", Host: "prod"}}
+			})
+			Expect(err).To(BeNil())
+
+			signer.ca = ca
+		})
+
+		It("should issue SPIFFE IDs of the CA's trust domain", func() {
+			config.SPIFFEID = &url.URL{Scheme: "spiffe", Host: "prod", Path: "/service/web"}
+
+			_, err := drv.Issue(context.Background(), config)
+			Expect(err).To(BeNil())
+			Expect(signer.csrs[0].URIs).To(Equal([]*url.URL{config.SPIFFEID}))
+		})
+
+		It("should refuse SPIFFE IDs of other trust domains", func() {
+			config.SPIFFEID = &url.URL{Scheme: "spiffe", Host: "staging", Path: "/service/web"}
+
+			_, err := drv.Issue(context.Background(), config)
+			Expect(err.Error()).To(Equal("SPIFFE ID trust domain staging does not match CA's trust domain prod"))
+			Expect(signer.csrs).To(BeEmpty())
+		})
+	})
+
+	It("should not allow choosing the signature algorithm", func() {
+		config.SignatureAlgorithm = x509.ECDSAWithSHA384
+
+		_, err := drv.Issue(context.Background(), config)
+		Expect(err.Error()).To(Equal("signature algorithm can not be requested for CA remote, which is signed by a signing backend"))
+	})
+})
@@ -1,43 +1,94 @@
 package driver
 
 import (
-	"bytes"
 	"context"
 	"crypto/rand"
-	"crypto/rsa"
-	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
-	"encoding/pem"
 	"fmt"
+	"net/url"
 	"time"
 
-	"github.com/docker/docker/client"
+	"github.com/docker/docker/api/types/swarm"
 	"github.com/docker/go-plugins-helpers/secrets"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
 
-// PrivateKeyLength specifies the length for RSA private keys generated.
+// PrivateKeyLength specifies the default length for RSA private keys generated.
 const PrivateKeyLength int = 2048
 
-// CABackend declares interface for loading root CAs.
+// CABackend declares interface for loading CAs.
 type CABackend interface {
-	Load(name string) (*tls.Certificate, error)
+	Load(ctx context.Context, name string) (*CA, error)
+}
+
+// SigningBackend declares interface for CAs whose private keys never leave
+// the backend. The driver generates the key and sends a CSR to be signed.
+type SigningBackend interface {
+	// Signs reports whether the backend signs certificates of the CA.
+	Signs(name string) bool
+
+	// Sign signs the CSR with the CA according to the request, returning
+	// the certificate and its CA chain.
+	Sign(ctx context.Context, name string, csr *x509.CertificateRequest, config CertRequest) (*x509.Certificate, []*x509.Certificate, error)
+}
+
+// DockerClient declares the Docker API calls used by the driver.
+type DockerClient interface {
+	SecretInspectWithRaw(ctx context.Context, id string) (swarm.Secret, []byte, error)
+	ServiceInspectWithRaw(ctx context.Context, serviceID string) (swarm.Service, []byte, error)
+}
+
+// Policy decides whether a certificate request may be issued.
+type Policy interface {
+	Check(request secrets.Request, config CertRequest) error
+}
+
+// Authorizer makes the final decision on a certificate request. It either
+// denies the request with an error, or returns the request to issue, which
+// may be modified.
+type Authorizer interface {
+	Authorize(ctx context.Context, request secrets.Request, config CertRequest) (CertRequest, error)
+}
+
+// ApprovalGate holds back certificate requests until they are approved.
+type ApprovalGate interface {
+	Check(request secrets.Request, config CertRequest) error
 }
 
 // NewDriver creates a new PKI driver.
-func NewDriver(ca CABackend, client *client.Client) (*Driver, error) {
-	return &Driver{
-		ca:     ca,
-		client: client,
-	}, nil
+func NewDriver(ca CABackend, client DockerClient, opts ...Option) (*Driver, error) {
+	d := &Driver{
+		ca:        ca,
+		client:    client,
+		configDir: DefaultConfigDir,
+		groups:    newGroupCache(DefaultGroupTTL),
+		timeout:   DefaultRequestTimeout,
+	}
+
+	for _, opt := range opts {
+		if err := opt(d); err != nil {
+			return nil, err
+		}
+	}
+
+	return d, nil
 }
 
 // Driver is the TLS certificate issuer.
 type Driver struct {
-	ca     CABackend
-	client *client.Client
+	ca         CABackend
+	signer     SigningBackend
+	client     DockerClient
+	configDir  string
+	groups     *groupCache
+	timeout    time.Duration
+	identity   *identity
+	policy     Policy
+	authorizer Authorizer
+	approvals  ApprovalGate
+	keys       *KeyPool
 }
 
 // Get retrieves a PKI certificate bundle from the issuing backend
@@ -52,9 +103,12 @@ func (d Driver) Get(request secrets.Request) secrets.Response {
 
 	zap.S().Debugf("pki: got request for certificate: %#v", request)
 
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+
 	// For now the secrets.Request.SecretLabels value does not get populated.
 	// To work around this, the secret's labels are inspected on the daemon.
-	meta, _, err := d.client.SecretInspectWithRaw(context.Background(), request.SecretName)
+	meta, _, err := d.client.SecretInspectWithRaw(ctx, request.SecretName)
 	if err != nil {
 		msg := fmt.Sprintf("pki: error inspecting secret: %s", err.Error())
 
@@ -67,7 +121,7 @@ func (d Driver) Get(request secrets.Request) secrets.Response {
 	}
 
 	certRequest := CertRequest{}
-	if err := certRequest.FromSecretLabels(meta.Spec.Labels); err != nil {
+	if err := certRequest.FromSecretLabels(meta.Spec.Labels, request); err != nil {
 		msg := fmt.Sprintf("pki: error parsing secret's labels: %s", err.Error())
 
 		// The error is explicitly logged because Docker doesn't log the error returned.
@@ -78,7 +132,142 @@ func (d Driver) Get(request secrets.Request) secrets.Response {
 		}
 	}
 
-	bundle, err := d.IssueCertificate(certRequest)
+	if certRequest.Kind == KindTrust {
+		return d.getTrustBundle(ctx, request, certRequest)
+	}
+
+	if err := d.applyAutoSANs(ctx, request, &certRequest); err != nil {
+		msg := fmt.Sprintf("pki: error deriving SANs from service: %s", err.Error())
+
+		// The error is explicitly logged because Docker doesn't log the error returned.
+		zap.S().Error(msg)
+
+		return secrets.Response{
+			Err: msg,
+		}
+	}
+
+	if d.identity != nil {
+		if err := d.identity.apply(workloadFromRequest(request), &certRequest); err != nil {
+			msg := fmt.Sprintf("pki: error assigning workload identity: %s", err.Error())
+
+			// The error is explicitly logged because Docker doesn't log the error returned.
+			zap.S().Error(msg)
+
+			return secrets.Response{
+				Err: msg,
+			}
+		}
+	}
+
+	// The CA is loaded after the plugin's checks, so that denied requests
+	// don't reach the CA backend.
+	if err := d.checkPolicies(request, nil, certRequest); err != nil {
+		msg := fmt.Sprintf("pki: certificate request denied: %s", err.Error())
+
+		// The error is explicitly logged because Docker doesn't log the error returned.
+		zap.S().Error(msg)
+		auditDenied(request, certRequest, err)
+
+		return secrets.Response{
+			Err: msg,
+		}
+	}
+
+	if d.authorizer != nil {
+		authorized, err := d.authorizer.Authorize(ctx, request, certRequest)
+		if err != nil {
+			msg := fmt.Sprintf("pki: certificate request denied: %s", err.Error())
+
+			// The error is explicitly logged because Docker doesn't log the error returned.
+			zap.S().Error(msg)
+			auditDenied(request, certRequest, err)
+
+			return secrets.Response{
+				Err: msg,
+			}
+		}
+
+		// Requests modified by the authorizer must still pass the policies.
+		if err := d.checkPolicies(request, nil, authorized); err != nil {
+			msg := fmt.Sprintf("pki: certificate request denied: request modified by authorizer: %s", err.Error())
+
+			// The error is explicitly logged because Docker doesn't log the error returned.
+			zap.S().Error(msg)
+			auditDenied(request, authorized, err)
+
+			return secrets.Response{
+				Err: msg,
+			}
+		}
+
+		// Settings chosen by the authorizer take precedence over the CA's
+		// defaults.
+		if authorized.Lifetime != certRequest.Lifetime {
+			authorized.defaultLifetime = false
+		}
+
+		if authorized.KeyType != certRequest.KeyType || authorized.KeySize != certRequest.KeySize {
+			authorized.defaultKey = false
+		}
+
+		certRequest = authorized
+	}
+
+	if d.approvals != nil {
+		if err := d.approvals.Check(request, certRequest); err != nil {
+			msg := fmt.Sprintf("pki: certificate request not approved: %s", err.Error())
+
+			// The error is explicitly logged because Docker doesn't log the error returned.
+			zap.S().Error(msg)
+			auditDenied(request, certRequest, err)
+
+			return secrets.Response{
+				Err: msg,
+			}
+		}
+	}
+
+	ca, err := d.loadCA(ctx, certRequest.CAName)
+	if err != nil {
+		msg := fmt.Sprintf("pki: error issuing certificate: %s", err.Error())
+
+		// The error is explicitly logged because Docker doesn't log the error returned.
+		zap.S().Error(msg)
+
+		return secrets.Response{
+			Err: msg,
+		}
+	}
+
+	if ca != nil {
+		if err := ca.Config.applyDefaults(&certRequest); err != nil {
+			msg := fmt.Sprintf("pki: error issuing certificate: %s", err.Error())
+
+			// The error is explicitly logged because Docker doesn't log the error returned.
+			zap.S().Error(msg)
+
+			return secrets.Response{
+				Err: msg,
+			}
+		}
+
+		// The CA's defaults may change the request, so the plugin's policy
+		// is checked again along with the CA's policy.
+		if err := d.checkPolicies(request, ca, certRequest); err != nil {
+			msg := fmt.Sprintf("pki: certificate request denied: %s", err.Error())
+
+			// The error is explicitly logged because Docker doesn't log the error returned.
+			zap.S().Error(msg)
+			auditDenied(request, certRequest, err)
+
+			return secrets.Response{
+				Err: msg,
+			}
+		}
+	}
+
+	issued, err := d.issueFor(ctx, request, ca, certRequest)
 	if err != nil {
 		msg := fmt.Sprintf("pki: error issuing certificate: %s", err.Error())
 
@@ -90,27 +279,159 @@ func (d Driver) Get(request secrets.Request) secrets.Response {
 		}
 	}
 
+	bundle, err := d.encode(certRequest, issued)
+	if err != nil {
+		msg := fmt.Sprintf("pki: error encoding certificate: %s", err.Error())
+
+		// The error is explicitly logged because Docker doesn't log the error returned.
+		zap.S().Error(msg)
+
+		return secrets.Response{
+			Err: msg,
+		}
+	}
+
 	return secrets.Response{
 		Value:      bundle,
 		DoNotReuse: true,
 	}
 }
 
-// IssueCertificate creates a new TLS certificate with specified config.
-func (d Driver) IssueCertificate(config CertRequest) ([]byte, error) {
-	serial, err := rand.Int(rand.Reader, MaxSerialNumber)
+// checkPolicies checks the request against the policy of the CA, if loaded,
+// and the plugin's policy.
+func (d Driver) checkPolicies(request secrets.Request, ca *CA, config CertRequest) error {
+	if ca != nil {
+		if err := ca.Config.check(request, config); err != nil {
+			return err
+		}
+	}
+
+	if d.policy != nil {
+		return d.policy.Check(request, config)
+	}
+
+	return nil
+}
+
+// getTrustBundle creates a trust bundle response.
+func (d Driver) getTrustBundle(ctx context.Context, request secrets.Request, config CertRequest) secrets.Response {
+	bundle, err := d.IssueTrustBundle(ctx, config)
 	if err != nil {
-		return nil, errors.Wrap(err, "error generating certificate serial number")
+		msg := fmt.Sprintf("pki: error creating trust bundle: %s", err.Error())
+
+		// The error is explicitly logged because Docker doesn't log the error returned.
+		zap.S().Error(msg)
+
+		return secrets.Response{
+			Err: msg,
+		}
+	}
+
+	auditTrust(request, config)
+
+	return secrets.Response{
+		Value: bundle,
+	}
+}
+
+// IssueCertificate creates a new TLS certificate with specified config and
+// encodes it in the requested output format.
+func (d Driver) IssueCertificate(ctx context.Context, config CertRequest) ([]byte, error) {
+	if _, err := EncoderFor(config.Format); err != nil {
+		return nil, err
+	}
+
+	issued, err := d.Issue(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.encode(config, issued)
+}
+
+// issueFor issues a certificate for the request with the loaded CA. Material
+// is shared between the secrets of a group attached to the same task.
+func (d Driver) issueFor(ctx context.Context, request secrets.Request, ca *CA, config CertRequest) (*IssuedCertificate, error) {
+	issue := func() (*IssuedCertificate, error) {
+		issued, err := d.issue(ctx, ca, config)
+		if err != nil {
+			return nil, err
+		}
+
+		auditIssued(request, config, issued)
+
+		return issued, nil
+	}
+
+	if config.Group == "" {
+		return issue()
+	}
+
+	if request.TaskID == "" {
+		return nil, errors.New("secret groups can only be used for secrets attached to a task")
+	}
+
+	return d.groups.issue(config.Group, request.TaskID, config, issue)
+}
+
+// encode serializes the requested part of the issued certificate in the
+// requested output format, protecting the private key with the requested
+// passphrase.
+func (d Driver) encode(config CertRequest, issued *IssuedCertificate) ([]byte, error) {
+	encoder, err := EncoderFor(config.Format)
+	if err != nil {
+		return nil, err
 	}
 
-	ca, err := d.ca.Load(config.CAName)
+	issued, err = issued.Part(config.Part)
 	if err != nil {
-		return nil, errors.Wrap(err, "error loading CA bundle")
+		return nil, err
+	}
+
+	opts := EncodeOptions{}
+	if config.PassphraseSource != "" {
+		opts.Passphrase, err = d.resolvePassphrase(config.PassphraseSource)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	rootCert, err := x509.ParseCertificate(ca.Certificate[0])
+	return encoder.Encode(issued, opts)
+}
+
+// Issue creates a new TLS certificate with specified config.
+func (d Driver) Issue(ctx context.Context, config CertRequest) (*IssuedCertificate, error) {
+	ca, err := d.loadCA(ctx, config.CAName)
 	if err != nil {
-		return nil, errors.Wrap(err, "error parsing CA certificate")
+		return nil, err
+	}
+
+	if ca != nil {
+		if err := ca.Config.applyDefaults(&config); err != nil {
+			return nil, err
+		}
+	}
+
+	return d.issue(ctx, ca, config)
+}
+
+// issue creates a new TLS certificate signed by the CA, or by the signing
+// backend when the CA is nil.
+func (d Driver) issue(ctx context.Context, ca *CA, config CertRequest) (*IssuedCertificate, error) {
+	if ca == nil {
+		return d.issueDelegated(ctx, config)
+	}
+
+	serial, err := rand.Int(rand.Reader, MaxSerialNumber)
+	if err != nil {
+		return nil, errors.Wrap(err, "error generating certificate serial number")
+	}
+
+	rootCert := ca.Certificate()
+
+	signatureAlgorithm, err := signatureAlgorithmFor(ca.Signer, config.SignatureAlgorithm)
+	if err != nil {
+		return nil, err
 	}
 
 	now := time.Now()
@@ -131,6 +452,9 @@ func (d Driver) IssueCertificate(config CertRequest) ([]byte, error) {
 		NotAfter:    now.Add(config.Lifetime),
 		KeyUsage:    x509.KeyUsageDigitalSignature,
 		ExtKeyUsage: config.Usage,
+
+		SignatureAlgorithm: signatureAlgorithm,
+		ExtraExtensions:    config.Extensions,
 	}
 
 	for _, name := range config.DNSNames {
@@ -141,31 +465,37 @@ func (d Driver) IssueCertificate(config CertRequest) ([]byte, error) {
 		cert.IPAddresses = append(cert.IPAddresses, addr)
 	}
 
-	key, err := rsa.GenerateKey(rand.Reader, PrivateKeyLength)
+	for _, uri := range config.URIs {
+		cert.URIs = append(cert.URIs, uri)
+	}
+
+	if config.SPIFFEID != nil {
+		if err := validateTrustDomain(config.SPIFFEID, ca.TrustDomain()); err != nil {
+			return nil, err
+		}
+
+		cert.URIs = []*url.URL{config.SPIFFEID}
+	}
+
+	key, err := d.generateKey(config.KeyType, config.KeySize)
 	if err != nil {
 		return nil, errors.Wrap(err, "error generating private key")
 	}
 
-	signed, err := x509.CreateCertificate(rand.Reader, &cert, rootCert, &key.PublicKey, ca.PrivateKey)
+	signed, err := x509.CreateCertificate(rand.Reader, &cert, rootCert, key.Public(), ca.Signer)
 	if err != nil {
 		return nil, errors.Wrap(err, "error signing certificate")
 	}
 
-	bundle := &bytes.Buffer{}
-
-	if err := pem.Encode(bundle, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}); err != nil {
-		return nil, err
+	issued := &IssuedCertificate{
+		PrivateKey: key,
+		Chain:      append([]*x509.Certificate{}, ca.Chain...),
 	}
 
-	if err := pem.Encode(bundle, &pem.Block{Type: "CERTIFICATE", Bytes: signed}); err != nil {
-		return nil, err
-	}
-
-	for _, cert := range ca.Certificate {
-		if err := pem.Encode(bundle, &pem.Block{Type: "CERTIFICATE", Bytes: cert}); err != nil {
-			return nil, err
-		}
+	issued.Certificate, err = x509.ParseCertificate(signed)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing issued certificate")
 	}
 
-	return bundle.Bytes(), nil
+	return issued, nil
 }
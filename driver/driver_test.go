@@ -1,10 +1,21 @@
 package driver_test
 
 import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/pem"
+	"math/big"
 	"net"
+	"net/url"
+	"time"
 
 	"docker-secretprovider-pki/backend"
 	"docker-secretprovider-pki/driver"
@@ -29,7 +40,7 @@ var _ = Describe("PKI Docker secret provider driver", func() {
 			var bundle []byte
 
 			BeforeEach(func() {
-				bundle, err = drv.IssueCertificate(driver.CertRequest{
+				bundle, err = drv.IssueCertificate(context.Background(), driver.CertRequest{
 					CAName:     "test",
 					CommonName: "Test Certificate",
 					DNSNames:   []string{"smaily.testing", "local.smaily.testing"},
@@ -64,10 +75,298 @@ var _ = Describe("PKI Docker secret provider driver", func() {
 				Expect(signedCert.AuthorityKeyId).To(Equal(intermediateCert.SubjectKeyId))
 				Expect(intermediateCert.AuthorityKeyId).To(Equal(rootCert.SubjectKeyId))
 			})
+
+			It("should generate a RSA private key of default length", func() {
+				cert, err := parsePKIBundle(bundle)
+				Expect(err).To(BeNil())
+
+				key, ok := cert.PrivateKey.(*rsa.PrivateKey)
+				Expect(ok).To(BeTrue())
+				Expect(key.N.BitLen()).To(Equal(driver.PrivateKeyLength))
+			})
+		})
+
+		When("Certificate request specifies a key type", func() {
+			issue := func(keyType string, keySize int) *tls.Certificate {
+				bundle, err := drv.IssueCertificate(context.Background(), driver.CertRequest{
+					CAName:     "test",
+					CommonName: "Test Certificate",
+					Usage:      []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+					KeyType:    keyType,
+					KeySize:    keySize,
+				})
+				Expect(err).To(BeNil())
+
+				cert, err := parsePKIBundle(bundle)
+				Expect(err).To(BeNil())
+
+				return cert
+			}
+
+			It("should issue a certificate with RSA private key of requested length", func() {
+				cert := issue(driver.KeyTypeRSA, 3072)
+
+				key, ok := cert.PrivateKey.(*rsa.PrivateKey)
+				Expect(ok).To(BeTrue())
+				Expect(key.N.BitLen()).To(Equal(3072))
+			})
+
+			It("should issue a certificate with ECDSA private key on requested curve", func() {
+				cert := issue(driver.KeyTypeECDSA, 384)
+
+				key, ok := cert.PrivateKey.(*ecdsa.PrivateKey)
+				Expect(ok).To(BeTrue())
+				Expect(key.Curve).To(Equal(elliptic.P384()))
+
+				leaf, err := x509.ParseCertificate(cert.Certificate[0])
+				Expect(err).To(BeNil())
+				Expect(leaf.PublicKeyAlgorithm).To(Equal(x509.ECDSA))
+			})
+
+			It("should issue a certificate with Ed25519 private key", func() {
+				cert := issue(driver.KeyTypeEd25519, 0)
+
+				_, ok := cert.PrivateKey.(ed25519.PrivateKey)
+				Expect(ok).To(BeTrue())
+
+				leaf, err := x509.ParseCertificate(cert.Certificate[0])
+				Expect(err).To(BeNil())
+				Expect(leaf.PublicKeyAlgorithm).To(Equal(x509.Ed25519))
+			})
+
+			It("should reject unsupported key type and size combinations", func() {
+				_, err := drv.IssueCertificate(context.Background(), driver.CertRequest{
+					CAName:     "test",
+					CommonName: "Test Certificate",
+					KeyType:    driver.KeyTypeECDSA,
+					KeySize:    2048,
+				})
+				Expect(err).ToNot(BeNil())
+				Expect(err.Error()).To(ContainSubstring("unsupported key size for key type ecdsa: 2048"))
+			})
+		})
+
+		When("Certificate request specifies a signature algorithm", func() {
+			It("should sign the certificate with requested algorithm", func() {
+				bundle, err := drv.IssueCertificate(context.Background(), driver.CertRequest{
+					CAName:             "test",
+					CommonName:         "Test Certificate",
+					SignatureAlgorithm: x509.SHA384WithRSA,
+				})
+				Expect(err).To(BeNil())
+
+				cert, err := parsePKIBundle(bundle)
+				Expect(err).To(BeNil())
+
+				leaf, err := x509.ParseCertificate(cert.Certificate[0])
+				Expect(err).To(BeNil())
+				Expect(leaf.SignatureAlgorithm).To(Equal(x509.SHA384WithRSA))
+			})
+
+			It("should fail when the algorithm does not match CA key", func() {
+				_, err := drv.IssueCertificate(context.Background(), driver.CertRequest{
+					CAName:             "test",
+					CommonName:         "Test Certificate",
+					SignatureAlgorithm: x509.ECDSAWithSHA256,
+				})
+				Expect(err).ToNot(BeNil())
+				Expect(err.Error()).To(Equal("signature algorithm ECDSA-SHA256 can not be used with rsa CA key"))
+			})
+		})
+	})
+
+	Describe("Issuing certificates with SPIFFE IDs", func() {
+		var spiffeID *url.URL
+
+		BeforeEach(func() {
+			ca, err := newGeneratedCA(mustGenerateKey(), func(template *x509.Certificate) {
+				template.URIs = []*url.URL{{Scheme: "spiffe", Host: "example.org"}}
+			})
+			Expect(err).To(BeNil())
+
+			drv, err = driver.NewDriver(ca, nil)
+			Expect(err).To(BeNil())
+		})
+
+		issue := func() ([]byte, error) {
+			return drv.IssueCertificate(context.Background(), driver.CertRequest{
+				CAName:     "test",
+				CommonName: "Test Certificate",
+				URIs:       []*url.URL{{Scheme: "https", Host: "ignored.example.org"}},
+				SPIFFEID:   spiffeID,
+			})
+		}
+
+		When("SPIFFE ID belongs to CA's trust domain", func() {
+			BeforeEach(func() {
+				spiffeID, _ = driver.ParseSPIFFEID("spiffe://example.org/ns/prod/sa/web")
+			})
+
+			It("should issue a certificate with the SPIFFE ID as the only URI SAN", func() {
+				bundle, err := issue()
+				Expect(err).To(BeNil())
+
+				cert, err := parsePKIBundle(bundle)
+				Expect(err).To(BeNil())
+
+				leaf, err := x509.ParseCertificate(cert.Certificate[0])
+				Expect(err).To(BeNil())
+				Expect(leaf.URIs).To(HaveLen(1))
+				Expect(leaf.URIs[0].String()).To(Equal("spiffe://example.org/ns/prod/sa/web"))
+			})
+		})
+
+		When("SPIFFE ID belongs to another trust domain", func() {
+			BeforeEach(func() {
+				spiffeID, _ = driver.ParseSPIFFEID("spiffe://other.org/web")
+			})
+
+			It("should return a trust domain mismatch error", func() {
+				_, err := issue()
+				Expect(err.Error()).To(Equal("SPIFFE ID trust domain other.org does not match CA's trust domain example.org"))
+			})
+		})
+
+		When("CA has no trust domain configured", func() {
+			BeforeEach(func() {
+				spiffeID, _ = driver.ParseSPIFFEID("spiffe://example.org/web")
+				drv, err = driver.NewDriver(&backend.TestBackend{}, nil)
+				Expect(err).To(BeNil())
+			})
+
+			It("should return an error", func() {
+				_, err := issue()
+				Expect(err.Error()).To(Equal("CA has no SPIFFE trust domain configured"))
+			})
+		})
+	})
+
+	Describe("Issuing certificates with non-RSA CA keys", func() {
+		var ca *generatedCA
+
+		issue := func(config driver.CertRequest) *x509.Certificate {
+			drv, err := driver.NewDriver(ca, nil)
+			Expect(err).To(BeNil())
+
+			bundle, err := drv.IssueCertificate(context.Background(), config)
+			Expect(err).To(BeNil())
+
+			cert, err := parsePKIBundle(bundle)
+			Expect(err).To(BeNil())
+
+			leaf, err := x509.ParseCertificate(cert.Certificate[0])
+			Expect(err).To(BeNil())
+			Expect(leaf.CheckSignatureFrom(ca.cert)).To(Succeed())
+
+			return leaf
+		}
+
+		When("CA has an ECDSA P-384 key", func() {
+			BeforeEach(func() {
+				key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+				Expect(err).To(BeNil())
+
+				ca, err = newGeneratedCA(key)
+				Expect(err).To(BeNil())
+			})
+
+			It("should sign with a matching default algorithm", func() {
+				leaf := issue(driver.CertRequest{CAName: "test", CommonName: "Test Certificate"})
+				Expect(leaf.SignatureAlgorithm).To(Equal(x509.ECDSAWithSHA384))
+			})
+
+			It("should sign with requested algorithm", func() {
+				leaf := issue(driver.CertRequest{
+					CAName:             "test",
+					CommonName:         "Test Certificate",
+					SignatureAlgorithm: x509.ECDSAWithSHA256,
+				})
+				Expect(leaf.SignatureAlgorithm).To(Equal(x509.ECDSAWithSHA256))
+			})
+
+			It("should fail when the algorithm does not match CA key", func() {
+				drv, err := driver.NewDriver(ca, nil)
+				Expect(err).To(BeNil())
+
+				_, err = drv.IssueCertificate(context.Background(), driver.CertRequest{
+					CAName:             "test",
+					CommonName:         "Test Certificate",
+					SignatureAlgorithm: x509.PureEd25519,
+				})
+				Expect(err).ToNot(BeNil())
+				Expect(err.Error()).To(Equal("signature algorithm Ed25519 can not be used with ecdsa CA key"))
+			})
+		})
+
+		When("CA has an Ed25519 key", func() {
+			BeforeEach(func() {
+				_, key, err := ed25519.GenerateKey(rand.Reader)
+				Expect(err).To(BeNil())
+
+				ca, err = newGeneratedCA(key)
+				Expect(err).To(BeNil())
+			})
+
+			It("should sign with Ed25519", func() {
+				leaf := issue(driver.CertRequest{
+					CAName:     "test",
+					CommonName: "Test Certificate",
+					KeyType:    driver.KeyTypeECDSA,
+				})
+				Expect(leaf.SignatureAlgorithm).To(Equal(x509.PureEd25519))
+			})
 		})
 	})
 })
 
+// generatedCA is a CA backend serving a self-signed CA generated for a test.
+type generatedCA struct {
+	cert *x509.Certificate
+	ca   *driver.CA
+}
+
+func mustGenerateKey() crypto.Signer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).To(BeNil())
+
+	return key
+}
+
+func newGeneratedCA(key crypto.Signer, configure ...func(template *x509.Certificate)) (*generatedCA, error) {
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Generated Test Authority"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	for _, c := range configure {
+		c(template)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &generatedCA{
+		cert: cert,
+		ca:   &driver.CA{Chain: []*x509.Certificate{cert}, Signer: key},
+	}, nil
+}
+
+func (b *generatedCA) Load(ctx context.Context, name string) (*driver.CA, error) {
+	return b.ca, nil
+}
+
 func parsePKIBundle(bundle []byte) (cert *tls.Certificate, err error) {
 	raw := make([]byte, len(bundle))
 	copy(raw, bundle)
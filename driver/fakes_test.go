@@ -0,0 +1,36 @@
+package driver_test
+
+import (
+	"context"
+
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/pkg/errors"
+)
+
+// fakeDocker serves secrets' metadata in place of the Docker daemon.
+type fakeDocker struct {
+	secrets  map[string]map[string]string
+	services map[string]swarm.Service
+}
+
+func (f *fakeDocker) SecretInspectWithRaw(ctx context.Context, id string) (swarm.Secret, []byte, error) {
+	labels, exists := f.secrets[id]
+	if !exists {
+		return swarm.Secret{}, nil, errors.Errorf("secret %s not found", id)
+	}
+
+	secret := swarm.Secret{ID: id}
+	secret.Spec.Name = id
+	secret.Spec.Labels = labels
+
+	return secret, nil, nil
+}
+
+func (f *fakeDocker) ServiceInspectWithRaw(ctx context.Context, serviceID string) (swarm.Service, []byte, error) {
+	service, exists := f.services[serviceID]
+	if !exists {
+		return swarm.Service{}, nil, errors.Errorf("service %s not found", serviceID)
+	}
+
+	return service, nil, nil
+}
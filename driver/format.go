@@ -0,0 +1,269 @@
+package driver
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"sync"
+	"time"
+
+	"github.com/pavlo-v-chernykh/keystore-go/v4"
+	"github.com/pkg/errors"
+	"software.sslmate.com/src/go-pkcs12"
+
+	"docker-secretprovider-pki/pkcs8"
+)
+
+// Supported output formats for issued certificates.
+const (
+	FormatPEM    = "pem"
+	FormatPKCS12 = "pkcs12"
+	FormatJKS    = "jks"
+	FormatDER    = "der"
+	FormatJSON   = "json"
+)
+
+// Output format defaults.
+const (
+	DefaultFormat = FormatPEM
+
+	// DefaultKeystorePassword protects PKCS#12 and JKS keystores.
+	DefaultKeystorePassword = pkcs12.DefaultPassword
+
+	// KeystoreAlias is the alias of the private key entry in JKS keystores.
+	KeystoreAlias = "pki"
+)
+
+// EncodeOptions holds the parameters for encoding issued certificates.
+type EncodeOptions struct {
+	// Passphrase protects the private key, if set. Keystores fall back to
+	// DefaultKeystorePassword when it is not set.
+	Passphrase []byte
+}
+
+// keystorePassword returns the password for protecting keystores.
+func (o EncodeOptions) keystorePassword() string {
+	if len(o.Passphrase) == 0 {
+		return DefaultKeystorePassword
+	}
+
+	return string(o.Passphrase)
+}
+
+// Encoder serializes issued certificate material into a secret's value.
+type Encoder interface {
+	Encode(issued *IssuedCertificate, opts EncodeOptions) ([]byte, error)
+}
+
+// EncoderFunc allows using ordinary functions as encoders.
+type EncoderFunc func(issued *IssuedCertificate, opts EncodeOptions) ([]byte, error)
+
+// Encode calls f(issued, opts).
+func (f EncoderFunc) Encode(issued *IssuedCertificate, opts EncodeOptions) ([]byte, error) {
+	return f(issued, opts)
+}
+
+var (
+	encodersMu sync.RWMutex
+	encoders   = map[string]Encoder{
+		FormatPEM:    EncoderFunc(encodePEM),
+		FormatPKCS12: EncoderFunc(encodePKCS12),
+		FormatJKS:    EncoderFunc(encodeJKS),
+		FormatDER:    EncoderFunc(encodeDER),
+		FormatJSON:   EncoderFunc(encodeJSON),
+	}
+)
+
+// RegisterEncoder makes an encoder available for the format. Registering
+// an encoder for an existing format replaces it.
+func RegisterEncoder(format string, encoder Encoder) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+
+	encoders[format] = encoder
+}
+
+// EncoderFor looks up the encoder registered for the format.
+func EncoderFor(format string) (Encoder, error) {
+	if format == "" {
+		format = DefaultFormat
+	}
+
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+
+	if encoder, exists := encoders[format]; exists {
+		return encoder, nil
+	}
+
+	return nil, errors.Errorf("unsupported output format: %s", format)
+}
+
+// IssuedCertificate holds the material of an issued certificate.
+type IssuedCertificate struct {
+	PrivateKey  crypto.Signer
+	Certificate *x509.Certificate
+	Chain       []*x509.Certificate
+}
+
+// requireKeyPair checks the issued material holds both private key and
+// certificate, which formats other than PEM and DER can not do without.
+func requireKeyPair(issued *IssuedCertificate) error {
+	if issued.PrivateKey == nil || issued.Certificate == nil {
+		return errors.New("format requires both private key and certificate")
+	}
+
+	return nil
+}
+
+// encodePEM lays out private key, certificate and CA chain as PEM blocks.
+// Parts missing from the issued material are left out.
+func encodePEM(issued *IssuedCertificate, opts EncodeOptions) ([]byte, error) {
+	bundle := &bytes.Buffer{}
+
+	if issued.PrivateKey != nil {
+		keyBlock, err := encodeProtectedPrivateKey(issued.PrivateKey, opts.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := pem.Encode(bundle, keyBlock); err != nil {
+			return nil, err
+		}
+	}
+
+	if issued.Certificate != nil {
+		if err := pem.Encode(bundle, &pem.Block{Type: "CERTIFICATE", Bytes: issued.Certificate.Raw}); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, cert := range issued.Chain {
+		if err := pem.Encode(bundle, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}); err != nil {
+			return nil, err
+		}
+	}
+
+	return bundle.Bytes(), nil
+}
+
+// encodeDER returns the DER encoded certificate. Private key and CA chain
+// can not be represented alongside the certificate and are left out. When
+// the issued material holds no certificate, the PKCS#8 private key or the
+// concatenated CA chain is returned instead.
+func encodeDER(issued *IssuedCertificate, opts EncodeOptions) ([]byte, error) {
+	if issued.Certificate != nil {
+		return issued.Certificate.Raw, nil
+	}
+
+	if issued.PrivateKey != nil {
+		der, err := x509.MarshalPKCS8PrivateKey(issued.PrivateKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "error marshaling private key")
+		}
+
+		if len(opts.Passphrase) == 0 {
+			return der, nil
+		}
+
+		return pkcs8.Encrypt(der, opts.Passphrase)
+	}
+
+	var chain []byte
+	for _, cert := range issued.Chain {
+		chain = append(chain, cert.Raw...)
+	}
+
+	return chain, nil
+}
+
+// encodePKCS12 creates a PKCS#12 keystore holding the private key,
+// certificate and CA chain.
+func encodePKCS12(issued *IssuedCertificate, opts EncodeOptions) ([]byte, error) {
+	if err := requireKeyPair(issued); err != nil {
+		return nil, err
+	}
+
+	pfx, err := pkcs12.Encode(rand.Reader, issued.PrivateKey, issued.Certificate, issued.Chain, opts.keystorePassword())
+	if err != nil {
+		return nil, errors.Wrap(err, "error encoding PKCS#12 keystore")
+	}
+
+	return pfx, nil
+}
+
+// encodeJKS creates a Java keystore holding the private key entry with
+// the certificate chain.
+func encodeJKS(issued *IssuedCertificate, opts EncodeOptions) ([]byte, error) {
+	if err := requireKeyPair(issued); err != nil {
+		return nil, err
+	}
+
+	key, err := x509.MarshalPKCS8PrivateKey(issued.PrivateKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling private key")
+	}
+
+	entry := keystore.PrivateKeyEntry{
+		CreationTime: time.Now(),
+		PrivateKey:   key,
+		CertificateChain: []keystore.Certificate{
+			{Type: "X509", Content: issued.Certificate.Raw},
+		},
+	}
+
+	for _, cert := range issued.Chain {
+		entry.CertificateChain = append(entry.CertificateChain, keystore.Certificate{Type: "X509", Content: cert.Raw})
+	}
+
+	password := []byte(opts.keystorePassword())
+
+	ks := keystore.New()
+	if err := ks.SetPrivateKeyEntry(KeystoreAlias, entry, password); err != nil {
+		return nil, errors.Wrap(err, "error adding private key to JKS keystore")
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ks.Store(buf, password); err != nil {
+		return nil, errors.Wrap(err, "error encoding JKS keystore")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// jsonBundle is the document layout of JSON encoded bundles.
+type jsonBundle struct {
+	Key      string    `json:"key"`
+	Cert     string    `json:"cert"`
+	Chain    []string  `json:"chain"`
+	NotAfter time.Time `json:"not_after"`
+}
+
+// encodeJSON creates a JSON document holding PEM encoded private key,
+// certificate and CA chain, and certificate's expiry time.
+func encodeJSON(issued *IssuedCertificate, opts EncodeOptions) ([]byte, error) {
+	if err := requireKeyPair(issued); err != nil {
+		return nil, err
+	}
+
+	keyBlock, err := encodeProtectedPrivateKey(issued.PrivateKey, opts.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := jsonBundle{
+		Key:      string(pem.EncodeToMemory(keyBlock)),
+		Cert:     string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issued.Certificate.Raw})),
+		Chain:    []string{},
+		NotAfter: issued.Certificate.NotAfter.UTC(),
+	}
+
+	for _, cert := range issued.Chain {
+		doc.Chain = append(doc.Chain, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})))
+	}
+
+	return json.Marshal(doc)
+}
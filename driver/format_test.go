@@ -0,0 +1,129 @@
+package driver_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"time"
+
+	"github.com/pavlo-v-chernykh/keystore-go/v4"
+	"software.sslmate.com/src/go-pkcs12"
+
+	"docker-secretprovider-pki/backend"
+	"docker-secretprovider-pki/driver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Certificate output formats", func() {
+	var drv *driver.Driver
+
+	BeforeEach(func() {
+		var err error
+		drv, err = driver.NewDriver(&backend.TestBackend{}, nil)
+		Expect(err).To(BeNil())
+	})
+
+	issue := func(format string) []byte {
+		bundle, err := drv.IssueCertificate(context.Background(), driver.CertRequest{
+			CAName:     "test",
+			CommonName: "Test Certificate",
+			Usage:      []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			Lifetime:   time.Hour,
+			Format:     format,
+		})
+		Expect(err).To(BeNil())
+
+		return bundle
+	}
+
+	When("PEM format is requested", func() {
+		It("should lay out private key, certificate and CA chain", func() {
+			cert, err := parsePKIBundle(issue(driver.FormatPEM))
+			Expect(err).To(BeNil())
+
+			Expect(len(cert.Certificate)).To(Equal(3))
+			Expect(cert.PrivateKey).ToNot(BeNil())
+		})
+	})
+
+	When("Format is not specified", func() {
+		It("should default to PEM format", func() {
+			cert, err := parsePKIBundle(issue(""))
+			Expect(err).To(BeNil())
+
+			Expect(len(cert.Certificate)).To(Equal(3))
+		})
+	})
+
+	When("DER format is requested", func() {
+		It("should return the DER encoded certificate", func() {
+			cert, err := x509.ParseCertificate(issue(driver.FormatDER))
+			Expect(err).To(BeNil())
+
+			Expect(cert.Subject.CommonName).To(Equal("Test Certificate"))
+		})
+	})
+
+	When("PKCS#12 format is requested", func() {
+		It("should return a keystore protected with default password", func() {
+			key, cert, chain, err := pkcs12.DecodeChain(issue(driver.FormatPKCS12), driver.DefaultKeystorePassword)
+			Expect(err).To(BeNil())
+
+			Expect(key).ToNot(BeNil())
+			Expect(cert.Subject.CommonName).To(Equal("Test Certificate"))
+			Expect(len(chain)).To(Equal(2))
+		})
+	})
+
+	When("JKS format is requested", func() {
+		It("should return a keystore with the private key entry", func() {
+			ks := keystore.New()
+			Expect(ks.Load(bytes.NewReader(issue(driver.FormatJKS)), []byte(driver.DefaultKeystorePassword))).To(Succeed())
+
+			entry, err := ks.GetPrivateKeyEntry(driver.KeystoreAlias, []byte(driver.DefaultKeystorePassword))
+			Expect(err).To(BeNil())
+
+			Expect(entry.PrivateKey).ToNot(BeEmpty())
+			Expect(len(entry.CertificateChain)).To(Equal(3))
+		})
+	})
+
+	When("JSON format is requested", func() {
+		It("should return a document with PEM encoded material and expiry time", func() {
+			var doc struct {
+				Key      string    `json:"key"`
+				Cert     string    `json:"cert"`
+				Chain    []string  `json:"chain"`
+				NotAfter time.Time `json:"not_after"`
+			}
+			Expect(json.Unmarshal(issue(driver.FormatJSON), &doc)).To(Succeed())
+
+			Expect(doc.Key).To(ContainSubstring("RSA PRIVATE KEY"))
+			Expect(doc.Cert).To(ContainSubstring("CERTIFICATE"))
+			Expect(len(doc.Chain)).To(Equal(2))
+			Expect(doc.NotAfter).To(BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+		})
+	})
+
+	When("Format is not supported", func() {
+		It("should return an unsupported format error", func() {
+			_, err := drv.IssueCertificate(context.Background(), driver.CertRequest{CAName: "test", Format: "xml"})
+			Expect(err.Error()).To(Equal("unsupported output format: xml"))
+		})
+	})
+
+	When("Custom encoder is registered", func() {
+		BeforeEach(func() {
+			driver.RegisterEncoder("cn", driver.EncoderFunc(func(issued *driver.IssuedCertificate, opts driver.EncodeOptions) ([]byte, error) {
+				return []byte(issued.Certificate.Subject.CommonName), nil
+			}))
+		})
+
+		It("should encode the certificate using the encoder", func() {
+			Expect(string(issue("cn"))).To(Equal("Test Certificate"))
+		})
+	})
+})
@@ -0,0 +1,133 @@
+package driver
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultGroupTTL specifies how long issued material is remembered for
+// the other secrets of a group.
+var DefaultGroupTTL = 5 * time.Minute
+
+// Secret parts which can be requested from a group's issued material.
+const (
+	PartKey       = "key"
+	PartCert      = "cert"
+	PartChain     = "chain"
+	PartFullChain = "fullchain"
+	PartCA        = "ca"
+)
+
+// Part returns a copy of issued material holding only the requested part.
+func (c *IssuedCertificate) Part(part string) (*IssuedCertificate, error) {
+	switch part {
+	case "":
+		return c, nil
+	case PartKey:
+		return &IssuedCertificate{PrivateKey: c.PrivateKey}, nil
+	case PartCert:
+		return &IssuedCertificate{Certificate: c.Certificate}, nil
+	case PartChain:
+		return &IssuedCertificate{Chain: c.Chain}, nil
+	case PartFullChain:
+		return &IssuedCertificate{Certificate: c.Certificate, Chain: c.Chain}, nil
+	case PartCA:
+		if len(c.Chain) == 0 {
+			return nil, errors.New("issued certificate has no CA chain")
+		}
+		return &IssuedCertificate{Chain: c.Chain[len(c.Chain)-1:]}, nil
+	default:
+		return nil, errors.Errorf("unknown secret part: %s", part)
+	}
+}
+
+// groupEntry holds material issued for a group of secrets of a task.
+type groupEntry struct {
+	ready   chan struct{}
+	config  CertRequest
+	issued  *IssuedCertificate
+	err     error
+	expires time.Time
+}
+
+// groupCache remembers material issued for groups of secrets, so each
+// secret of a group attached to a task gets a matching part.
+type groupCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*groupEntry
+}
+
+func newGroupCache(ttl time.Duration) *groupCache {
+	return &groupCache{
+		ttl:     ttl,
+		entries: map[string]*groupEntry{},
+	}
+}
+
+// issue returns the material issued for the group of a task, calling
+// the issue function when the group has no material yet. Concurrent
+// requests for the same group wait for the first one to finish.
+func (g *groupCache) issue(group, task string, config CertRequest, issue func() (*IssuedCertificate, error)) (*IssuedCertificate, error) {
+	key := group + "/" + task
+	now := time.Now()
+
+	g.mu.Lock()
+	g.evict(now)
+
+	entry, exists := g.entries[key]
+	if !exists {
+		entry = &groupEntry{
+			ready:   make(chan struct{}),
+			config:  config,
+			expires: now.Add(g.ttl),
+		}
+		g.entries[key] = entry
+	}
+	g.mu.Unlock()
+
+	if !exists {
+		entry.issued, entry.err = issue()
+		close(entry.ready)
+
+		// Failed issuance is not remembered to allow retrying.
+		if entry.err != nil {
+			g.mu.Lock()
+			delete(g.entries, key)
+			g.mu.Unlock()
+		}
+	} else {
+		<-entry.ready
+	}
+
+	if entry.err != nil {
+		return nil, entry.err
+	}
+
+	if !sameIssuance(entry.config, config) {
+		return nil, errors.Errorf("secret's labels do not match the other secrets of group: %s", group)
+	}
+
+	return entry.issued, nil
+}
+
+// evict removes expired entries. Must be called with the lock held.
+func (g *groupCache) evict(now time.Time) {
+	for key, entry := range g.entries {
+		if now.After(entry.expires) {
+			delete(g.entries, key)
+		}
+	}
+}
+
+// sameIssuance reports whether two requests issue the same certificate,
+// disregarding how the issued material is delivered.
+func sameIssuance(a, b CertRequest) bool {
+	a.Part, a.Format, a.PassphraseSource = "", "", ""
+	b.Part, b.Format, b.PassphraseSource = "", "", ""
+
+	return reflect.DeepEqual(a, b)
+}
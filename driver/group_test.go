@@ -0,0 +1,154 @@
+package driver_test
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+
+	"github.com/docker/go-plugins-helpers/secrets"
+
+	"docker-secretprovider-pki/backend"
+	"docker-secretprovider-pki/driver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Secret groups", func() {
+	var (
+		drv    *driver.Driver
+		docker *fakeDocker
+	)
+
+	groupLabels := func(part string) map[string]string {
+		return map[string]string{
+			"pki.ca":    "test",
+			"pki.cn":    "Test Certificate",
+			"pki.usage": "server",
+			"pki.group": "web",
+			"pki.part":  part,
+		}
+	}
+
+	BeforeEach(func() {
+		docker = &fakeDocker{secrets: map[string]map[string]string{
+			"web_key":       groupLabels(driver.PartKey),
+			"web_cert":      groupLabels(driver.PartCert),
+			"web_chain":     groupLabels(driver.PartChain),
+			"web_fullchain": groupLabels(driver.PartFullChain),
+			"web_ca":        groupLabels(driver.PartCA),
+		}}
+
+		var err error
+		drv, err = driver.NewDriver(&backend.TestBackend{}, docker)
+		Expect(err).To(BeNil())
+	})
+
+	get := func(secret, task string) *x509.Certificate {
+		response := drv.Get(secrets.Request{SecretName: secret, TaskID: task})
+		Expect(response.Err).To(BeEmpty())
+
+		cert, err := parsePKIBundle(response.Value)
+		Expect(err).To(BeNil())
+		Expect(cert.Certificate).To(HaveLen(1))
+
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		Expect(err).To(BeNil())
+
+		return leaf
+	}
+
+	When("Secrets of a group are attached to the same task", func() {
+		It("should issue a private key matching the certificate", func() {
+			response := drv.Get(secrets.Request{SecretName: "web_key", TaskID: "task1"})
+			Expect(response.Err).To(BeEmpty())
+
+			key, err := parsePKIBundle(response.Value)
+			Expect(err).To(BeNil())
+			Expect(key.Certificate).To(BeEmpty())
+
+			cert := get("web_cert", "task1")
+			Expect(key.PrivateKey.(crypto.Signer).Public()).To(Equal(cert.PublicKey))
+		})
+
+		It("should issue the chain of the certificate", func() {
+			cert := get("web_cert", "task1")
+
+			response := drv.Get(secrets.Request{SecretName: "web_chain", TaskID: "task1"})
+			Expect(response.Err).To(BeEmpty())
+
+			chain, err := parsePKIBundle(response.Value)
+			Expect(err).To(BeNil())
+			Expect(chain.Certificate).To(HaveLen(2))
+
+			issuer, err := x509.ParseCertificate(chain.Certificate[0])
+			Expect(err).To(BeNil())
+			Expect(cert.CheckSignatureFrom(issuer)).To(Succeed())
+		})
+
+		It("should issue the full chain starting with the certificate", func() {
+			cert := get("web_cert", "task1")
+
+			response := drv.Get(secrets.Request{SecretName: "web_fullchain", TaskID: "task1"})
+			Expect(response.Err).To(BeEmpty())
+
+			fullchain, err := parsePKIBundle(response.Value)
+			Expect(err).To(BeNil())
+			Expect(fullchain.PrivateKey).To(BeNil())
+			Expect(fullchain.Certificate).To(HaveLen(3))
+			Expect(fullchain.Certificate[0]).To(Equal(cert.Raw))
+		})
+
+		It("should issue the root CA certificate", func() {
+			ca := get("web_ca", "task1")
+			Expect(ca.IsCA).To(BeTrue())
+			Expect(ca.Subject.CommonName).To(Equal("PKI Provider Test Root CA test"))
+		})
+	})
+
+	When("Secrets of a group are attached to different tasks", func() {
+		It("should issue different certificates", func() {
+			first := get("web_cert", "task1")
+			second := get("web_cert", "task2")
+
+			Expect(first.SerialNumber).ToNot(Equal(second.SerialNumber))
+		})
+	})
+
+	When("Secrets of a group request different certificates", func() {
+		BeforeEach(func() {
+			docker.secrets["web_key"]["pki.cn"] = "Other Certificate"
+		})
+
+		It("should return a mismatch error", func() {
+			get("web_cert", "task1")
+
+			response := drv.Get(secrets.Request{SecretName: "web_key", TaskID: "task1"})
+			Expect(response.Err).To(ContainSubstring("secret's labels do not match the other secrets of group: web"))
+		})
+	})
+
+	When("Secret of a group is not attached to a task", func() {
+		It("should return an error", func() {
+			response := drv.Get(secrets.Request{SecretName: "web_cert"})
+			Expect(response.Err).To(ContainSubstring("secret groups can only be used for secrets attached to a task"))
+		})
+	})
+
+	When("Key part is requested in DER format", func() {
+		BeforeEach(func() {
+			docker.secrets["web_key"]["pki.format"] = driver.FormatDER
+		})
+
+		It("should return a PKCS#8 private key", func() {
+			response := drv.Get(secrets.Request{SecretName: "web_key", TaskID: "task1"})
+			Expect(response.Err).To(BeEmpty())
+
+			block, _ := pem.Decode(response.Value)
+			Expect(block).To(BeNil())
+
+			_, err := x509.ParsePKCS8PrivateKey(response.Value)
+			Expect(err).To(BeNil())
+		})
+	})
+})
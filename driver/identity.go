@@ -0,0 +1,154 @@
+package driver
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/docker/go-plugins-helpers/secrets"
+	"github.com/pkg/errors"
+)
+
+// StackNamespaceLabel is the service label Docker sets for services of a stack.
+const StackNamespaceLabel = "com.docker.stack.namespace"
+
+// IdentityConfig configures the workload identity embedded in issued
+// certificates.
+type IdentityConfig struct {
+	// URITemplate is a text/template rendering the workload's URI SAN,
+	// e.g. `spiffe://cluster/stack/{{.Namespace}}/service/{{.ServiceName}}`.
+	URITemplate string
+
+	// ExtensionOID enables embedding service and task IDs in a custom
+	// certificate extension with the OID, e.g. `1.3.6.1.4.1.<PEN>.1`.
+	ExtensionOID string
+}
+
+// Workload holds the identity of the Swarm task a secret is issued to.
+type Workload struct {
+	Namespace   string
+	ServiceName string
+	ServiceID   string
+	TaskName    string
+	TaskID      string
+}
+
+// workloadFromRequest extracts the workload's identity from the request.
+func workloadFromRequest(request secrets.Request) Workload {
+	return Workload{
+		Namespace:   request.ServiceLabels[StackNamespaceLabel],
+		ServiceName: request.ServiceName,
+		ServiceID:   request.ServiceID,
+		TaskName:    request.TaskName,
+		TaskID:      request.TaskID,
+	}
+}
+
+// workloadExtension is the ASN.1 layout of the workload identity extension.
+type workloadExtension struct {
+	ServiceID string `asn1:"utf8"`
+	TaskID    string `asn1:"utf8"`
+}
+
+// identity embeds the workload identity in certificate requests.
+type identity struct {
+	uri          *template.Template
+	extensionOID asn1.ObjectIdentifier
+}
+
+func newIdentity(config IdentityConfig) (*identity, error) {
+	id := &identity{}
+
+	if config.URITemplate != "" {
+		tmpl, err := template.New("identity").Option("missingkey=error").Parse(config.URITemplate)
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing workload identity URI template")
+		}
+
+		id.uri = tmpl
+	}
+
+	if config.ExtensionOID != "" {
+		for _, arc := range strings.Split(config.ExtensionOID, ".") {
+			n, err := strconv.Atoi(arc)
+			if err != nil || n < 0 {
+				return nil, errors.Errorf("invalid workload identity extension OID: %s", config.ExtensionOID)
+			}
+
+			id.extensionOID = append(id.extensionOID, n)
+		}
+
+		if len(id.extensionOID) < 2 {
+			return nil, errors.Errorf("invalid workload identity extension OID: %s", config.ExtensionOID)
+		}
+	}
+
+	return id, nil
+}
+
+// apply adds the workload's URI SAN and identity extension to the
+// certificate request.
+func (id *identity) apply(workload Workload, config *CertRequest) error {
+	if id.uri != nil {
+		if workload.ServiceName == "" {
+			return errors.New("workload identity can only be issued for secrets attached to a service")
+		}
+
+		buf := &bytes.Buffer{}
+		if err := id.uri.Execute(buf, workload); err != nil {
+			return errors.Wrap(err, "error rendering workload identity URI")
+		}
+
+		if err := id.applyURI(buf.String(), config); err != nil {
+			return err
+		}
+	}
+
+	if id.extensionOID != nil {
+		value, err := asn1.Marshal(workloadExtension{
+			ServiceID: workload.ServiceID,
+			TaskID:    workload.TaskID,
+		})
+		if err != nil {
+			return errors.Wrap(err, "error marshaling workload identity extension")
+		}
+
+		config.Extensions = append(config.Extensions, pkix.Extension{Id: id.extensionOID, Value: value})
+	}
+
+	return nil
+}
+
+// applyURI sets the workload's URI SAN. SPIFFE IDs are the only URI SAN of
+// a certificate, so secrets can not request their own URIs in that case,
+// nor a SPIFFE ID along with other identity URIs.
+func (id *identity) applyURI(raw string, config *CertRequest) error {
+	uri, err := url.Parse(raw)
+	if err != nil {
+		return errors.Errorf("error parsing workload identity URI from: '%s'", raw)
+	}
+
+	if uri.Scheme != SPIFFEScheme {
+		if config.SPIFFEID != nil {
+			return errors.New("label 'pki.spiffe_id' can not be used with workload identity URI that is not a SPIFFE ID")
+		}
+
+		config.URIs = append(config.URIs, uri)
+		return nil
+	}
+
+	if config.SPIFFEID != nil || len(config.URIs) > 0 {
+		return errors.New("labels 'pki.spiffe_id' and 'pki.uris' can not be used with workload identity")
+	}
+
+	config.SPIFFEID, err = ParseSPIFFEID(raw)
+	if err != nil {
+		return errors.Wrap(err, "invalid workload identity")
+	}
+
+	return nil
+}
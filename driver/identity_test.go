@@ -0,0 +1,149 @@
+package driver_test
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"net/url"
+
+	"github.com/docker/go-plugins-helpers/secrets"
+
+	"docker-secretprovider-pki/driver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Workload identity", func() {
+	var (
+		ca      *generatedCA
+		docker  *fakeDocker
+		request secrets.Request
+	)
+
+	BeforeEach(func() {
+		var err error
+		ca, err = newGeneratedCA(mustGenerateKey(), func(template *x509.Certificate) {
+			template.URIs = []*url.URL{{Scheme: "spiffe", Host: "swarm.test"}}
+		})
+		Expect(err).To(BeNil())
+
+		docker = &fakeDocker{secrets: map[string]map[string]string{
+			"web": {
+				"pki.ca":    "test",
+				"pki.cn":    "web",
+				"pki.usage": "server",
+			},
+		}}
+
+		request = secrets.Request{
+			SecretName:    "web",
+			ServiceName:   "shop_web",
+			ServiceID:     "service-id",
+			ServiceLabels: map[string]string{driver.StackNamespaceLabel: "shop"},
+			TaskName:      "shop_web.1.abc",
+			TaskID:        "task-id",
+		}
+	})
+
+	get := func(config driver.IdentityConfig) (*x509.Certificate, string) {
+		drv, err := driver.NewDriver(ca, docker, driver.WithIdentity(config))
+		Expect(err).To(BeNil())
+
+		response := drv.Get(request)
+		if response.Err != "" {
+			return nil, response.Err
+		}
+
+		bundle, err := parsePKIBundle(response.Value)
+		Expect(err).To(BeNil())
+
+		leaf, err := x509.ParseCertificate(bundle.Certificate[0])
+		Expect(err).To(BeNil())
+
+		return leaf, ""
+	}
+
+	When("Identity URI template is configured", func() {
+		config := driver.IdentityConfig{
+			URITemplate: "spiffe://swarm.test/stack/{{.Namespace}}/service/{{.ServiceName}}",
+		}
+
+		It("should embed the workload's SPIFFE ID", func() {
+			leaf, msg := get(config)
+			Expect(msg).To(BeEmpty())
+
+			Expect(leaf.URIs).To(HaveLen(1))
+			Expect(leaf.URIs[0].String()).To(Equal("spiffe://swarm.test/stack/shop/service/shop_web"))
+		})
+
+		It("should refuse secrets requesting their own SPIFFE ID", func() {
+			docker.secrets["web"]["pki.spiffe_id"] = "spiffe://swarm.test/admin"
+
+			_, msg := get(config)
+			Expect(msg).To(ContainSubstring("labels 'pki.spiffe_id' and 'pki.uris' can not be used with workload identity"))
+		})
+
+		It("should refuse secrets not attached to a service", func() {
+			request.ServiceName = ""
+
+			_, msg := get(config)
+			Expect(msg).To(ContainSubstring("workload identity can only be issued for secrets attached to a service"))
+		})
+	})
+
+	When("Identity URI template is not a SPIFFE ID", func() {
+		It("should add the URI to requested URIs", func() {
+			docker.secrets["web"]["pki.uris"] = "https://web.test"
+
+			leaf, msg := get(driver.IdentityConfig{URITemplate: "urn:swarm:{{.ServiceID}}"})
+			Expect(msg).To(BeEmpty())
+
+			Expect(leaf.URIs).To(HaveLen(2))
+			Expect(leaf.URIs[1].String()).To(Equal("urn:swarm:service-id"))
+		})
+
+		It("should refuse secrets requesting a SPIFFE ID", func() {
+			docker.secrets["web"]["pki.spiffe_id"] = "spiffe://swarm.test/web"
+
+			_, msg := get(driver.IdentityConfig{URITemplate: "urn:swarm:{{.ServiceID}}"})
+			Expect(msg).To(ContainSubstring("label 'pki.spiffe_id' can not be used with workload identity URI that is not a SPIFFE ID"))
+		})
+	})
+
+	When("Identity URI template references unknown fields", func() {
+		It("should return a template error", func() {
+			_, msg := get(driver.IdentityConfig{URITemplate: "spiffe://swarm.test/{{.Cluster}}"})
+			Expect(msg).To(ContainSubstring("error rendering workload identity URI"))
+		})
+	})
+
+	When("Identity extension is configured", func() {
+		It("should embed service and task IDs in the extension", func() {
+			leaf, msg := get(driver.IdentityConfig{ExtensionOID: "1.3.6.1.4.1.99999.1"})
+			Expect(msg).To(BeEmpty())
+
+			var value struct {
+				ServiceID string `asn1:"utf8"`
+				TaskID    string `asn1:"utf8"`
+			}
+
+			found := false
+			for _, ext := range leaf.Extensions {
+				if ext.Id.Equal(asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}) {
+					_, err := asn1.Unmarshal(ext.Value, &value)
+					Expect(err).To(BeNil())
+					found = true
+				}
+			}
+
+			Expect(found).To(BeTrue())
+			Expect(value.ServiceID).To(Equal("service-id"))
+			Expect(value.TaskID).To(Equal("task-id"))
+		})
+
+		It("should reject invalid OIDs", func() {
+			_, err := driver.NewDriver(ca, docker, driver.WithIdentity(driver.IdentityConfig{ExtensionOID: "not.an.oid"}))
+			Expect(err.Error()).To(Equal("invalid workload identity extension OID: not.an.oid"))
+		})
+	})
+})
@@ -0,0 +1,287 @@
+package driver
+
+import (
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// KeyPoolFile is the name of the key pool's configuration file in the
+// plugin's config directory.
+const KeyPoolFile = "keypool.json"
+
+// DefaultKeyPoolConcurrency is how many keys the pool generates at once by
+// default.
+const DefaultKeyPoolConcurrency = 1
+
+// Failed key generations are retried after a back off, starting at
+// keyPoolRetry and doubling up to keyPoolMaxRetry.
+const (
+	keyPoolRetry    = time.Second
+	keyPoolMaxRetry = time.Minute
+)
+
+// KeyPoolConfig configures the pool of pre-generated private keys.
+type KeyPoolConfig struct {
+	// Keys lists the types of keys kept pre-generated.
+	Keys []PooledKeyConfig `json:"keys"`
+
+	// Concurrency limits how many keys are generated at once when refilling
+	// the pool.
+	Concurrency int `json:"concurrency"`
+}
+
+// PooledKeyConfig configures the pre-generated keys of a key type.
+type PooledKeyConfig struct {
+	KeyType string `json:"key_type"`
+	KeySize int    `json:"key_size"`
+
+	// Size is the number of keys the pool holds ready.
+	Size int `json:"size"`
+}
+
+// KeyPoolStats describes the pre-generated keys of a key type.
+type KeyPoolStats struct {
+	// Size is the number of keys the pool is refilled to.
+	Size int `json:"size"`
+
+	// Depth is the number of keys ready in the pool.
+	Depth int `json:"depth"`
+
+	// Misses counts keys requested while the pool was empty, which were
+	// generated inline.
+	Misses uint64 `json:"misses"`
+
+	// Failures counts failed key generations, which are retried.
+	Failures uint64 `json:"failures"`
+}
+
+// LoadKeyPoolConfig reads the key pool's configuration from a JSON file.
+func LoadKeyPoolConfig(filename string) (KeyPoolConfig, error) {
+	config := KeyPoolConfig{}
+
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return config, errors.Wrap(err, "error reading key pool configuration")
+	}
+
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return config, errors.Wrap(err, "error parsing key pool configuration")
+	}
+
+	return config, nil
+}
+
+// NewKeyPool creates a key pool and starts filling it in the background.
+func NewKeyPool(config KeyPoolConfig) (*KeyPool, error) {
+	if config.Concurrency == 0 {
+		config.Concurrency = DefaultKeyPoolConcurrency
+	}
+
+	if config.Concurrency < 0 {
+		return nil, errors.New("key pool concurrency can not be negative")
+	}
+
+	p := &KeyPool{pools: map[string]*pooledKeys{}, done: make(chan struct{})}
+	p.cond = sync.NewCond(&p.mu)
+
+	for _, c := range config.Keys {
+		keyType, keySize, err := NormalizeKeyParams(c.KeyType, c.KeySize)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid pooled key")
+		}
+
+		name := keyName(keyType, keySize)
+		if _, exists := p.pools[name]; exists {
+			return nil, errors.Errorf("key pool configured more than once for key: %s", name)
+		}
+
+		if c.Size <= 0 {
+			return nil, errors.Errorf("size of key pool must be positive for key: %s", name)
+		}
+
+		p.pools[name] = &pooledKeys{keyType: keyType, keySize: keySize, size: c.Size}
+	}
+
+	for i := 0; i < config.Concurrency; i++ {
+		p.wg.Add(1)
+		go p.refill()
+	}
+
+	return p, nil
+}
+
+// KeyPool holds private keys generated in the background, so that issuing
+// certificates doesn't wait for the key generation, which is slow for RSA
+// keys.
+type KeyPool struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	pools  map[string]*pooledKeys
+	closed bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// pooledKeys are the pre-generated keys of a key type.
+type pooledKeys struct {
+	keyType string
+	keySize int
+	size    int
+
+	keys     []crypto.Signer
+	pending  int
+	misses   uint64
+	failures uint64
+}
+
+// Take returns a pre-generated key of the type and size, and whether the
+// pool had one ready.
+func (p *KeyPool) Take(keyType string, size int) (crypto.Signer, bool) {
+	keyType, size, err := NormalizeKeyParams(keyType, size)
+	if err != nil {
+		return nil, false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pool, exists := p.pools[keyName(keyType, size)]
+	if !exists {
+		return nil, false
+	}
+
+	if len(pool.keys) == 0 {
+		pool.misses++
+		return nil, false
+	}
+
+	key := pool.keys[len(pool.keys)-1]
+	pool.keys[len(pool.keys)-1] = nil
+	pool.keys = pool.keys[:len(pool.keys)-1]
+
+	p.cond.Signal()
+
+	return key, true
+}
+
+// Stats returns the state of the pool by key, e.g. `rsa-2048`.
+func (p *KeyPool) Stats() map[string]KeyPoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := map[string]KeyPoolStats{}
+	for name, pool := range p.pools {
+		stats[name] = KeyPoolStats{
+			Size:     pool.size,
+			Depth:    len(pool.keys),
+			Misses:   pool.misses,
+			Failures: pool.failures,
+		}
+	}
+
+	return stats
+}
+
+// Close stops refilling the pool, waiting for keys being generated.
+func (p *KeyPool) Close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.done)
+	}
+	p.cond.Broadcast()
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
+
+// refill generates keys until the pool is closed, waiting while the pool is
+// full. Failed generations are retried after backing off.
+func (p *KeyPool) refill() {
+	defer p.wg.Done()
+
+	retry := keyPoolRetry
+
+	for {
+		p.mu.Lock()
+		pool := p.next()
+		for pool == nil && !p.closed {
+			p.cond.Wait()
+			pool = p.next()
+		}
+
+		if p.closed {
+			p.mu.Unlock()
+			return
+		}
+
+		pool.pending++
+		p.mu.Unlock()
+
+		key, err := GeneratePrivateKey(pool.keyType, pool.keySize)
+
+		p.mu.Lock()
+		pool.pending--
+		if err == nil {
+			pool.keys = append(pool.keys, key)
+		} else {
+			pool.failures++
+		}
+		p.mu.Unlock()
+
+		if err == nil {
+			retry = keyPoolRetry
+			continue
+		}
+
+		// Key parameters are validated by NewKeyPool, so this is a failure
+		// of the random source, which may recover.
+		zap.S().Errorf("pki: error generating pooled key %s, retrying in %s: %s", keyName(pool.keyType, pool.keySize), retry, err)
+
+		timer := time.NewTimer(retry)
+		select {
+		case <-timer.C:
+		case <-p.done:
+			timer.Stop()
+			return
+		}
+
+		if retry *= 2; retry > keyPoolMaxRetry {
+			retry = keyPoolMaxRetry
+		}
+	}
+}
+
+// next returns the emptiest pool missing keys, if any. The caller holds the
+// lock.
+func (p *KeyPool) next() *pooledKeys {
+	var next *pooledKeys
+	for _, pool := range p.pools {
+		filled := len(pool.keys) + pool.pending
+		if filled >= pool.size {
+			continue
+		}
+
+		if next == nil || filled*next.size < (len(next.keys)+next.pending)*pool.size {
+			next = pool
+		}
+	}
+
+	return next
+}
+
+// keyName names a key type and size, e.g. `rsa-2048` or `ed25519`.
+func keyName(keyType string, size int) string {
+	if size == 0 {
+		return keyType
+	}
+
+	return fmt.Sprintf("%s-%d", keyType, size)
+}
@@ -0,0 +1,151 @@
+package driver_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"docker-secretprovider-pki/backend"
+	"docker-secretprovider-pki/driver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Key pool", func() {
+	var pool *driver.KeyPool
+
+	newPool := func(config driver.KeyPoolConfig) *driver.KeyPool {
+		var err error
+		pool, err = driver.NewKeyPool(config)
+		Expect(err).To(BeNil())
+		return pool
+	}
+
+	depth := func(name string) func() int {
+		return func() int { return pool.Stats()[name].Depth }
+	}
+
+	AfterEach(func() {
+		if pool != nil {
+			pool.Close()
+			pool = nil
+		}
+	})
+
+	It("should fill the pool to its size", func() {
+		newPool(driver.KeyPoolConfig{
+			Keys: []driver.PooledKeyConfig{
+				{KeyType: driver.KeyTypeEd25519, Size: 3},
+				{KeyType: driver.KeyTypeECDSA, Size: 2},
+			},
+			Concurrency: 2,
+		})
+
+		Eventually(depth("ed25519")).Should(Equal(3))
+		Eventually(depth("ecdsa-256")).Should(Equal(2))
+		Consistently(depth("ed25519")).Should(Equal(3))
+	})
+
+	It("should hand out keys of the requested type and refill the pool", func() {
+		newPool(driver.KeyPoolConfig{Keys: []driver.PooledKeyConfig{{KeyType: driver.KeyTypeECDSA, KeySize: 384, Size: 1}}})
+		Eventually(depth("ecdsa-384")).Should(Equal(1))
+
+		key, ok := pool.Take(driver.KeyTypeECDSA, 384)
+		Expect(ok).To(BeTrue())
+		Expect(key.(*ecdsa.PrivateKey).Curve.Params().BitSize).To(Equal(384))
+
+		Eventually(depth("ecdsa-384")).Should(Equal(1))
+		Expect(pool.Stats()["ecdsa-384"].Misses).To(BeZero())
+	})
+
+	It("should count misses of empty pools", func() {
+		newPool(driver.KeyPoolConfig{Keys: []driver.PooledKeyConfig{{KeyType: driver.KeyTypeEd25519, Size: 2}}})
+		Eventually(depth("ed25519")).Should(Equal(2))
+		pool.Close()
+
+		for i := 0; i < 2; i++ {
+			_, ok := pool.Take(driver.KeyTypeEd25519, 0)
+			Expect(ok).To(BeTrue())
+		}
+
+		_, ok := pool.Take(driver.KeyTypeEd25519, 0)
+		Expect(ok).To(BeFalse())
+		Expect(pool.Stats()["ed25519"]).To(Equal(driver.KeyPoolStats{Size: 2, Depth: 0, Misses: 1}))
+	})
+
+	It("should not hand out keys of types not pooled", func() {
+		newPool(driver.KeyPoolConfig{Keys: []driver.PooledKeyConfig{{KeyType: driver.KeyTypeEd25519, Size: 1}}})
+
+		_, ok := pool.Take(driver.KeyTypeECDSA, 0)
+		Expect(ok).To(BeFalse())
+		Expect(pool.Stats()).To(HaveLen(1))
+	})
+
+	It("should refuse invalid configuration", func() {
+		for config, message := range map[*driver.KeyPoolConfig]string{
+			{Keys: []driver.PooledKeyConfig{{KeyType: "dsa", Size: 1}}}:                        "invalid pooled key: unsupported key type: dsa",
+			{Keys: []driver.PooledKeyConfig{{KeyType: driver.KeyTypeRSA, Size: 0}}}:            "size of key pool must be positive for key: rsa-2048",
+			{Keys: []driver.PooledKeyConfig{{Size: 1}, {KeyType: driver.KeyTypeRSA, Size: 1}}}: "key pool configured more than once for key: rsa-2048",
+			{Concurrency: -1}: "key pool concurrency can not be negative",
+		} {
+			_, err := driver.NewKeyPool(*config)
+			Expect(err).To(MatchError(message))
+		}
+	})
+
+	It("should load the configuration from a file", func() {
+		dir, err := ioutil.TempDir("", "keypool")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+
+		filename := filepath.Join(dir, driver.KeyPoolFile)
+		Expect(ioutil.WriteFile(filename, []byte(`{"keys": [{"key_type": "rsa", "key_size": 3072, "size": 10}], "concurrency": 4}`), 0600)).To(Succeed())
+
+		config, err := driver.LoadKeyPoolConfig(filename)
+		Expect(err).To(BeNil())
+		Expect(config).To(Equal(driver.KeyPoolConfig{
+			Keys:        []driver.PooledKeyConfig{{KeyType: driver.KeyTypeRSA, KeySize: 3072, Size: 10}},
+			Concurrency: 4,
+		}))
+	})
+
+	Context("when issuing certificates", func() {
+		var drv *driver.Driver
+
+		BeforeEach(func() {
+			newPool(driver.KeyPoolConfig{Keys: []driver.PooledKeyConfig{{KeyType: driver.KeyTypeEd25519, Size: 1}}})
+			Eventually(depth("ed25519")).Should(Equal(1))
+			pool.Close()
+
+			var err error
+			drv, err = driver.NewDriver(&backend.TestBackend{}, nil, driver.WithKeyPool(pool))
+			Expect(err).To(BeNil())
+		})
+
+		issue := func() *driver.IssuedCertificate {
+			issued, err := drv.Issue(context.Background(), driver.CertRequest{
+				CAName:     "test",
+				CommonName: "app.internal",
+				KeyType:    driver.KeyTypeEd25519,
+			})
+			Expect(err).To(BeNil())
+			Expect(issued.PrivateKey).To(BeAssignableToTypeOf(ed25519.PrivateKey{}))
+			return issued
+		}
+
+		It("should take keys from the pool", func() {
+			issue()
+			Expect(pool.Stats()["ed25519"]).To(Equal(driver.KeyPoolStats{Size: 1, Depth: 0, Misses: 0}))
+		})
+
+		It("should generate keys when the pool is empty", func() {
+			issue()
+			issue()
+			Expect(pool.Stats()["ed25519"].Misses).To(Equal(uint64(1)))
+		})
+	})
+})
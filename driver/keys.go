@@ -0,0 +1,139 @@
+package driver
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+
+	"github.com/pkg/errors"
+
+	"docker-secretprovider-pki/pkcs8"
+)
+
+// Supported private key types.
+const (
+	KeyTypeRSA     = "rsa"
+	KeyTypeECDSA   = "ecdsa"
+	KeyTypeEd25519 = "ed25519"
+)
+
+// Private key generation defaults.
+const (
+	DefaultKeyType  = KeyTypeRSA
+	DefaultECDSAKey = 256
+)
+
+// Allowed key sizes per key type. Ed25519 keys have a fixed size and do
+// not accept a size.
+var allowedKeySizes = map[string][]int{
+	KeyTypeRSA:   {2048, 3072, 4096},
+	KeyTypeECDSA: {256, 384},
+}
+
+// NormalizeKeyParams fills in defaults for an unspecified key type and size,
+// and validates the resulting combination.
+func NormalizeKeyParams(keyType string, size int) (string, int, error) {
+	if keyType == "" {
+		keyType = DefaultKeyType
+	}
+
+	switch keyType {
+	case KeyTypeRSA:
+		if size == 0 {
+			size = PrivateKeyLength
+		}
+	case KeyTypeECDSA:
+		if size == 0 {
+			size = DefaultECDSAKey
+		}
+	case KeyTypeEd25519:
+		if size != 0 {
+			return "", 0, errors.Errorf("key size can not be specified for key type: %s", keyType)
+		}
+		return keyType, 0, nil
+	default:
+		return "", 0, errors.Errorf("unsupported key type: %s", keyType)
+	}
+
+	for _, allowed := range allowedKeySizes[keyType] {
+		if size == allowed {
+			return keyType, size, nil
+		}
+	}
+
+	return "", 0, errors.Errorf("unsupported key size for key type %s: %d", keyType, size)
+}
+
+// GeneratePrivateKey creates a new private key of requested type and size.
+func GeneratePrivateKey(keyType string, size int) (crypto.Signer, error) {
+	keyType, size, err := NormalizeKeyParams(keyType, size)
+	if err != nil {
+		return nil, err
+	}
+
+	switch keyType {
+	case KeyTypeRSA:
+		return rsa.GenerateKey(rand.Reader, size)
+	case KeyTypeECDSA:
+		curve := elliptic.P256()
+		if size == 384 {
+			curve = elliptic.P384()
+		}
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	default:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+}
+
+// generateKey returns a private key of requested type and size, taken from
+// the key pool when the pool has one ready.
+func (d Driver) generateKey(keyType string, size int) (crypto.Signer, error) {
+	if d.keys != nil {
+		if key, ok := d.keys.Take(keyType, size); ok {
+			return key, nil
+		}
+	}
+
+	return GeneratePrivateKey(keyType, size)
+}
+
+// encodePrivateKey marshals the private key into a PEM block. RSA keys are
+// encoded as PKCS#1 for compatibility, other key types use PKCS#8.
+func encodePrivateKey(key crypto.Signer) (*pem.Block, error) {
+	if k, ok := key.(*rsa.PrivateKey); ok {
+		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}, nil
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling private key")
+	}
+
+	return &pem.Block{Type: "PRIVATE KEY", Bytes: der}, nil
+}
+
+// encodeProtectedPrivateKey marshals the private key into a PEM block,
+// encrypting it as PKCS#8 when a passphrase is given.
+func encodeProtectedPrivateKey(key crypto.Signer, passphrase []byte) (*pem.Block, error) {
+	if len(passphrase) == 0 {
+		return encodePrivateKey(key)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling private key")
+	}
+
+	encrypted, err := pkcs8.Encrypt(der, passphrase)
+	if err != nil {
+		return nil, errors.Wrap(err, "error encrypting private key")
+	}
+
+	return &pem.Block{Type: pkcs8.PEMType, Bytes: encrypted}, nil
+}
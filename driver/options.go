@@ -0,0 +1,98 @@
+package driver
+
+import "time"
+
+// DefaultConfigDir is the directory the plugin's configuration is mounted to.
+const DefaultConfigDir = "/etc/docker/pki"
+
+// DefaultRequestTimeout limits how long the driver spends on a request,
+// including calls to the Docker daemon and backends.
+const DefaultRequestTimeout = 30 * time.Second
+
+// Option configures optional driver features.
+type Option func(d *Driver) error
+
+// WithGroupTTL sets how long issued material is remembered for the other
+// secrets of a group.
+func WithGroupTTL(ttl time.Duration) Option {
+	return func(d *Driver) error {
+		d.groups = newGroupCache(ttl)
+		return nil
+	}
+}
+
+// WithRequestTimeout sets how long the driver spends on a request.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(d *Driver) error {
+		d.timeout = timeout
+		return nil
+	}
+}
+
+// WithConfigDir sets the directory passphrase files and other configuration
+// is looked up from.
+func WithConfigDir(dir string) Option {
+	return func(d *Driver) error {
+		d.configDir = dir
+		return nil
+	}
+}
+
+// WithIdentity enables embedding the workload's identity in issued
+// certificates.
+func WithIdentity(config IdentityConfig) Option {
+	return func(d *Driver) error {
+		id, err := newIdentity(config)
+		if err != nil {
+			return err
+		}
+
+		d.identity = id
+		return nil
+	}
+}
+
+// WithPolicy makes the driver check certificate requests against the policy
+// before issuing them.
+func WithPolicy(policy Policy) Option {
+	return func(d *Driver) error {
+		d.policy = policy
+		return nil
+	}
+}
+
+// WithAuthorizer makes the driver ask the authorizer for the final decision
+// on certificate requests allowed by the policy.
+func WithAuthorizer(authorizer Authorizer) Option {
+	return func(d *Driver) error {
+		d.authorizer = authorizer
+		return nil
+	}
+}
+
+// WithApprovalGate holds back certificate requests until the gate passes
+// them.
+func WithApprovalGate(gate ApprovalGate) Option {
+	return func(d *Driver) error {
+		d.approvals = gate
+		return nil
+	}
+}
+
+// WithKeyPool makes the driver take private keys of issued certificates from
+// the pool of pre-generated keys.
+func WithKeyPool(pool *KeyPool) Option {
+	return func(d *Driver) error {
+		d.keys = pool
+		return nil
+	}
+}
+
+// WithSigningBackend delegates signing certificates of the CAs the signing
+// backend signs for.
+func WithSigningBackend(signer SigningBackend) Option {
+	return func(d *Driver) error {
+		d.signer = signer
+		return nil
+	}
+}
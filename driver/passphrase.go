@@ -0,0 +1,46 @@
+package driver
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Passphrase sources are resolved relative to the config directory. Named
+// passphrases are looked up from the passphrases directory.
+const (
+	passphraseFilePrefix = "file:"
+	passphraseSecretsDir = "passphrases"
+)
+
+// resolvePassphrase reads the passphrase from the source, which is either
+// `file:<path>` relative to config directory or the name of a passphrase in
+// the passphrases directory.
+func (d Driver) resolvePassphrase(source string) ([]byte, error) {
+	var path string
+	if strings.HasPrefix(source, passphraseFilePrefix) {
+		path = strings.TrimPrefix(source, passphraseFilePrefix)
+	} else {
+		path = filepath.Join(passphraseSecretsDir, source)
+	}
+
+	path = filepath.Clean(path)
+	if path == "." || filepath.IsAbs(path) || path == ".." || strings.HasPrefix(path, "../") {
+		return nil, errors.Errorf("passphrase source must be within config directory: %s", source)
+	}
+
+	raw, err := ioutil.ReadFile(filepath.Join(d.configDir, path))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading passphrase from: %s", source)
+	}
+
+	passphrase := bytes.TrimRight(raw, "\r\n")
+	if len(passphrase) == 0 {
+		return nil, errors.Errorf("passphrase from %s is empty", source)
+	}
+
+	return passphrase, nil
+}
@@ -0,0 +1,93 @@
+package driver_test
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"software.sslmate.com/src/go-pkcs12"
+
+	"docker-secretprovider-pki/backend"
+	"docker-secretprovider-pki/driver"
+	"docker-secretprovider-pki/pkcs8"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Passphrase protected private keys", func() {
+	var (
+		drv       *driver.Driver
+		configDir string
+	)
+
+	BeforeEach(func() {
+		var err error
+		configDir, err = ioutil.TempDir("", "pki-config")
+		Expect(err).To(BeNil())
+
+		Expect(os.MkdirAll(filepath.Join(configDir, "passphrases"), 0700)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(configDir, "passphrases", "app_passphrase"), []byte("secret\n"), 0600)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(configDir, "keystore.pass"), []byte("keystore"), 0600)).To(Succeed())
+
+		drv, err = driver.NewDriver(&backend.TestBackend{}, nil, driver.WithConfigDir(configDir))
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(configDir)
+	})
+
+	issue := func(format, source string) ([]byte, error) {
+		return drv.IssueCertificate(context.Background(), driver.CertRequest{
+			CAName:           "test",
+			CommonName:       "Test Certificate",
+			Format:           format,
+			PassphraseSource: source,
+		})
+	}
+
+	When("Passphrase is sourced by name from the passphrases directory", func() {
+		It("should encrypt the private key in PEM bundle as PKCS#8", func() {
+			bundle, err := issue(driver.FormatPEM, "app_passphrase")
+			Expect(err).To(BeNil())
+
+			block, _ := pem.Decode(bundle)
+			Expect(block.Type).To(Equal("ENCRYPTED PRIVATE KEY"))
+
+			der, err := pkcs8.Decrypt(block.Bytes, []byte("secret"))
+			Expect(err).To(BeNil())
+
+			_, err = x509.ParsePKCS8PrivateKey(der)
+			Expect(err).To(BeNil())
+		})
+	})
+
+	When("Passphrase is sourced from a file in config directory", func() {
+		It("should protect PKCS#12 keystore with the passphrase", func() {
+			bundle, err := issue(driver.FormatPKCS12, "file:keystore.pass")
+			Expect(err).To(BeNil())
+
+			key, _, _, err := pkcs12.DecodeChain(bundle, "keystore")
+			Expect(err).To(BeNil())
+			Expect(key).ToNot(BeNil())
+		})
+	})
+
+	When("Passphrase source is outside config directory", func() {
+		It("should return an error", func() {
+			_, err := issue(driver.FormatPEM, "file:../etc/passwd")
+			Expect(err.Error()).To(Equal("passphrase source must be within config directory: file:../etc/passwd"))
+		})
+	})
+
+	When("Passphrase source does not exist", func() {
+		It("should return an error", func() {
+			_, err := issue(driver.FormatPEM, "missing")
+			Expect(err.Error()).To(ContainSubstring("error reading passphrase from: missing"))
+		})
+	})
+})
@@ -0,0 +1,126 @@
+package driver_test
+
+import (
+	"context"
+
+	"github.com/docker/go-plugins-helpers/secrets"
+	"github.com/pkg/errors"
+
+	"docker-secretprovider-pki/backend"
+	"docker-secretprovider-pki/driver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// policyFunc allows using ordinary functions as policies.
+type policyFunc func(request secrets.Request, config driver.CertRequest) error
+
+func (f policyFunc) Check(request secrets.Request, config driver.CertRequest) error {
+	return f(request, config)
+}
+
+// countingBackend counts the CAs loaded from the test backend.
+type countingBackend struct {
+	backend.TestBackend
+	loads int
+}
+
+func (b *countingBackend) Load(ctx context.Context, name string) (*driver.CA, error) {
+	b.loads++
+	return b.TestBackend.Load(ctx, name)
+}
+
+var _ = Describe("Issuance policy", func() {
+	var docker *fakeDocker
+
+	BeforeEach(func() {
+		docker = &fakeDocker{secrets: map[string]map[string]string{
+			"web": {
+				"pki.ca":        "test",
+				"pki.cn":        "web",
+				"pki.usage":     "server",
+				"pki.dns_names": "web.internal",
+			},
+		}}
+	})
+
+	When("Policy denies the request", func() {
+		It("should return the policy violation", func() {
+			drv, err := driver.NewDriver(&backend.TestBackend{}, docker, driver.WithPolicy(policyFunc(
+				func(request secrets.Request, config driver.CertRequest) error {
+					return errors.Errorf("DNS name %q is not allowed for CA %s", config.DNSNames[0], config.CAName)
+				},
+			)))
+			Expect(err).To(BeNil())
+
+			response := drv.Get(secrets.Request{SecretName: "web"})
+			Expect(response.Err).To(Equal(`pki: certificate request denied: DNS name "web.internal" is not allowed for CA test`))
+			Expect(response.Value).To(BeEmpty())
+		})
+
+		It("should not load the CA", func() {
+			ca := &countingBackend{}
+			drv, err := driver.NewDriver(ca, docker, driver.WithPolicy(policyFunc(
+				func(request secrets.Request, config driver.CertRequest) error {
+					return errors.New("denied")
+				},
+			)))
+			Expect(err).To(BeNil())
+
+			drv.Get(secrets.Request{SecretName: "web"})
+			Expect(ca.loads).To(BeZero())
+		})
+	})
+
+	When("Policy allows the request", func() {
+		It("should issue the certificate", func() {
+			drv, err := driver.NewDriver(&backend.TestBackend{}, docker, driver.WithPolicy(policyFunc(
+				func(request secrets.Request, config driver.CertRequest) error {
+					return nil
+				},
+			)))
+			Expect(err).To(BeNil())
+
+			response := drv.Get(secrets.Request{SecretName: "web"})
+			Expect(response.Err).To(BeEmpty())
+			Expect(response.Value).ToNot(BeEmpty())
+		})
+	})
+})
+
+var _ = Describe("Approval gate", func() {
+	It("should return the gate's error", func() {
+		docker := &fakeDocker{secrets: map[string]map[string]string{
+			"web": {"pki.ca": "test", "pki.cn": "web", "pki.usage": "server"},
+		}}
+
+		drv, err := driver.NewDriver(&backend.TestBackend{}, docker, driver.WithApprovalGate(policyFunc(
+			func(request secrets.Request, config driver.CertRequest) error {
+				return errors.New("request 0123456789abcdef is pending approval")
+			},
+		)))
+		Expect(err).To(BeNil())
+
+		response := drv.Get(secrets.Request{SecretName: "web"})
+		Expect(response.Err).To(Equal("pki: certificate request not approved: request 0123456789abcdef is pending approval"))
+		Expect(response.Value).To(BeEmpty())
+	})
+
+	It("should hold back requests before loading the CA", func() {
+		docker := &fakeDocker{secrets: map[string]map[string]string{
+			"web": {"pki.ca": "test", "pki.cn": "web", "pki.usage": "server"},
+		}}
+
+		ca := &countingBackend{}
+		drv, err := driver.NewDriver(ca, docker, driver.WithApprovalGate(policyFunc(
+			func(request secrets.Request, config driver.CertRequest) error {
+				return errors.New("request 0123456789abcdef is pending approval")
+			},
+		)))
+		Expect(err).To(BeNil())
+
+		drv.Get(secrets.Request{SecretName: "web"})
+		Expect(ca.loads).To(BeZero())
+	})
+})
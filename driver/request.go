@@ -2,12 +2,16 @@ package driver
 
 import (
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"fmt"
 	"math/big"
 	"net"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/docker/go-plugins-helpers/secrets"
 	"github.com/pkg/errors"
 )
 
@@ -20,22 +24,65 @@ var (
 
 // CertRequest specifies the configuration for a new certificate.
 type CertRequest struct {
+	Kind       string             `label:"pki.kind"`
 	CAName     string             `label:"pki.ca"`
+	TrustedCAs []string           `label:"pki.ca"`
 	CommonName string             `label:"pki.cn"`
 	DNSNames   []string           `label:"pki.dns_names"`
 	IPAddrs    []net.IP           `label:"pki.ip_addrs"`
+	URIs       []*url.URL         `label:"pki.uris"`
+	SPIFFEID   *url.URL           `label:"pki.spiffe_id"`
+	AutoSANs   []string           `label:"pki.auto_sans"`
 	Usage      []x509.ExtKeyUsage `label:"pki.usage"`
 	Lifetime   time.Duration      `label:"pki.lifetime"`
+	KeyType    string             `label:"pki.key_type"`
+	KeySize    int                `label:"pki.key_size"`
+
+	SignatureAlgorithm x509.SignatureAlgorithm `label:"pki.signature_algorithm"`
+	Format             string                  `label:"pki.format"`
+	PassphraseSource   string                  `label:"pki.passphrase_source"`
+	Part               string                  `label:"pki.part"`
+	Group              string                  `label:"pki.group"`
+
+	// Extensions are added to the certificate by the driver, e.g. for
+	// embedding the workload's identity.
+	Extensions []pkix.Extension
+
+	// defaultLifetime and defaultKey mark settings filled in with the
+	// driver's defaults, which are replaced by the CA's defaults.
+	defaultLifetime bool
+	defaultKey      bool
 }
 
 // FromSecretLabels populates the configuration from a map of secret's labels.
-func (c *CertRequest) FromSecretLabels(labels map[string]string) error {
+// Labels are evaluated as templates with the request's metadata first.
+func (c *CertRequest) FromSecretLabels(labels map[string]string, request secrets.Request) error {
+	labels, err := renderLabels(labels, request)
+	if err != nil {
+		return err
+	}
+
 	if value, exists := labels["pki.ca"]; exists {
 		c.CAName = value
 	} else {
 		return errors.New("label 'pki.ca' is required to issue a certificate")
 	}
 
+	if value, exists := labels["pki.kind"]; exists {
+		switch value {
+		case KindCertificate, KindTrust:
+			c.Kind = value
+		default:
+			return errors.New(fmt.Sprintf("unknown secret kind requested: %s", value))
+		}
+	} else {
+		c.Kind = KindCertificate
+	}
+
+	if c.Kind == KindTrust {
+		return c.trustFromSecretLabels(labels)
+	}
+
 	if value, exists := labels["pki.cn"]; exists {
 		c.CommonName = value
 	} else {
@@ -72,6 +119,40 @@ func (c *CertRequest) FromSecretLabels(labels map[string]string) error {
 		}
 	}
 
+	if value, exists := labels["pki.auto_sans"]; exists {
+		for _, source := range strings.Split(value, ",") {
+			switch source {
+			case AutoSANService, AutoSANTasks, AutoSANAliases, AutoSANVIPs:
+				c.AutoSANs = append(c.AutoSANs, source)
+			default:
+				return errors.New(fmt.Sprintf("unknown automatic SAN source: %s", source))
+			}
+		}
+	}
+
+	if value, exists := labels["pki.uris"]; exists {
+		uris, err := ParseURIs(value)
+		if err != nil {
+			return err
+		}
+
+		c.URIs = uris
+	}
+
+	if value, exists := labels["pki.spiffe_id"]; exists {
+		// X509-SVIDs must contain exactly one URI SAN.
+		if len(c.URIs) > 0 {
+			return errors.New("labels 'pki.spiffe_id' and 'pki.uris' can not be used together")
+		}
+
+		id, err := ParseSPIFFEID(value)
+		if err != nil {
+			return err
+		}
+
+		c.SPIFFEID = id
+	}
+
 	if value, exists := labels["pki.lifetime"]; exists {
 		d, err := time.ParseDuration(value)
 		if err != nil {
@@ -81,7 +162,124 @@ func (c *CertRequest) FromSecretLabels(labels map[string]string) error {
 		c.Lifetime = d
 	} else {
 		c.Lifetime = DefaultCertLifetime
+		c.defaultLifetime = true
+	}
+
+	if value, exists := labels["pki.key_type"]; exists {
+		c.KeyType = value
+	}
+
+	if value, exists := labels["pki.key_size"]; exists {
+		size, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.New(fmt.Sprintf("error parsing requested key size: %s", err))
+		}
+
+		c.KeySize = size
+	}
+
+	c.defaultKey = c.KeyType == "" && c.KeySize == 0
+
+	keyType, keySize, err := NormalizeKeyParams(c.KeyType, c.KeySize)
+	if err != nil {
+		return err
+	}
+
+	c.KeyType = keyType
+	c.KeySize = keySize
+
+	if value, exists := labels["pki.signature_algorithm"]; exists {
+		algo, err := ParseSignatureAlgorithm(value)
+		if err != nil {
+			return err
+		}
+
+		c.SignatureAlgorithm = algo
+	}
+
+	if value, exists := labels["pki.format"]; exists {
+		if _, err := EncoderFor(value); err != nil {
+			return err
+		}
+
+		c.Format = value
+	} else {
+		c.Format = DefaultFormat
+	}
+
+	if value, exists := labels["pki.passphrase_source"]; exists {
+		if value == "" {
+			return errors.New("label 'pki.passphrase_source' can not be empty")
+		}
+
+		c.PassphraseSource = value
 	}
 
+	// Plugins can't read payloads of other Docker secrets, so the secret
+	// named by `pki.passphrase_secret` is looked up from the passphrases
+	// directory, where operators provision it.
+	if value, exists := labels["pki.passphrase_secret"]; exists {
+		if c.PassphraseSource != "" {
+			return errors.New("labels 'pki.passphrase_secret' and 'pki.passphrase_source' can not be used together")
+		}
+
+		if value == "" || strings.HasPrefix(value, passphraseFilePrefix) {
+			return errors.New("label 'pki.passphrase_secret' must be the name of a passphrase")
+		}
+
+		c.PassphraseSource = value
+	}
+
+	if value, exists := labels["pki.group"]; exists {
+		c.Group = value
+	}
+
+	if value, exists := labels["pki.part"]; exists {
+		switch value {
+		case PartKey, PartCert, PartChain, PartFullChain, PartCA:
+		default:
+			return errors.New(fmt.Sprintf("unknown secret part requested: %s", value))
+		}
+
+		if c.Group == "" {
+			return errors.New("label 'pki.group' is required to issue a part of certificate")
+		}
+
+		if c.Format != FormatPEM && c.Format != FormatDER {
+			return errors.New(fmt.Sprintf("secret parts can not be issued in format: %s", c.Format))
+		}
+
+		if c.Format == FormatDER && value == PartFullChain {
+			return errors.New("secret part 'fullchain' can not be issued in format: der")
+		}
+
+		c.Part = value
+	}
+
+	return nil
+}
+
+// trustFromSecretLabels populates the configuration of a trust bundle, which
+// holds only the certificates of comma separated list of CAs.
+func (c *CertRequest) trustFromSecretLabels(labels map[string]string) error {
+	for _, name := range strings.Split(c.CAName, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return errors.New("label 'pki.ca' contains an empty CA name")
+		}
+
+		c.TrustedCAs = append(c.TrustedCAs, name)
+	}
+
+	if value, exists := labels["pki.format"]; exists && value != FormatPEM {
+		return errors.New(fmt.Sprintf("trust bundles can not be issued in format: %s", value))
+	}
+
+	if _, exists := labels["pki.part"]; exists {
+		return errors.New("label 'pki.part' can not be used for trust bundles")
+	}
+
+	c.Format = FormatPEM
+
 	return nil
 }
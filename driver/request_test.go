@@ -5,9 +5,12 @@ import (
 	"net"
 	"time"
 
+	"github.com/docker/go-plugins-helpers/secrets"
+
 	"docker-secretprovider-pki/driver"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 )
 
@@ -15,10 +18,12 @@ var _ = Describe("Certificate request object", func() {
 	var (
 		certRequest driver.CertRequest
 		labels      map[string]string
+		request     secrets.Request
 	)
 
 	BeforeEach(func() {
 		certRequest = driver.CertRequest{}
+		request = secrets.Request{}
 		labels = map[string]string{
 			"pki.ca":        "test",
 			"pki.cn":        "test certificate",
@@ -26,13 +31,20 @@ var _ = Describe("Certificate request object", func() {
 			"pki.lifetime":  "24h",
 			"pki.dns_names": "server.test,cluster.server.test,node.cluster.server.test",
 			"pki.ip_addrs":  "127.0.0.1,172.16.0.1",
+			"pki.uris":      "https://server.test/api,urn:test:server",
+			"pki.key_type":  "ecdsa",
+			"pki.key_size":  "384",
+
+			"pki.signature_algorithm": "SHA384WithRSA",
+			"pki.format":              "json",
+			"pki.passphrase_source":   "app_passphrase",
 		}
 	})
 
 	Describe("Loading request from label values", func() {
 		When("Labels are parsed", func() {
 			BeforeEach(func() {
-				err := certRequest.FromSecretLabels(labels)
+				err := certRequest.FromSecretLabels(labels, request)
 				Expect(err).To(BeNil())
 			})
 
@@ -59,6 +71,40 @@ var _ = Describe("Certificate request object", func() {
 			It("should extract IP addresses", func() {
 				Expect(certRequest.IPAddrs).To(ConsistOf(net.ParseIP("127.0.0.1"), net.ParseIP("172.16.0.1")))
 			})
+
+			It("should extract URIs", func() {
+				Expect(certRequest.URIs).To(HaveLen(2))
+				Expect(certRequest.URIs[0].String()).To(Equal("https://server.test/api"))
+				Expect(certRequest.URIs[1].String()).To(Equal("urn:test:server"))
+			})
+
+			It("should extract key type and size", func() {
+				Expect(certRequest.KeyType).To(Equal(driver.KeyTypeECDSA))
+				Expect(certRequest.KeySize).To(Equal(384))
+			})
+
+			It("should extract signature algorithm", func() {
+				Expect(certRequest.SignatureAlgorithm).To(Equal(x509.SHA384WithRSA))
+			})
+
+			It("should extract output format", func() {
+				Expect(certRequest.Format).To(Equal(driver.FormatJSON))
+			})
+
+			It("should extract passphrase source", func() {
+				Expect(certRequest.PassphraseSource).To(Equal("app_passphrase"))
+			})
+		})
+
+		When("Passphrase is specified as a secret", func() {
+			It("should use the secret's name as passphrase source", func() {
+				delete(labels, "pki.passphrase_source")
+				labels["pki.passphrase_secret"] = "app_passphrase"
+
+				err := certRequest.FromSecretLabels(labels, request)
+				Expect(err).To(BeNil())
+				Expect(certRequest.PassphraseSource).To(Equal("app_passphrase"))
+			})
 		})
 	})
 
@@ -69,7 +115,7 @@ var _ = Describe("Certificate request object", func() {
 			})
 
 			It("should return a required field error", func() {
-				err := certRequest.FromSecretLabels(labels)
+				err := certRequest.FromSecretLabels(labels, request)
 				Expect(err.Error()).To(Equal("label 'pki.ca' is required to issue a certificate"))
 			})
 		})
@@ -80,7 +126,7 @@ var _ = Describe("Certificate request object", func() {
 			})
 
 			It("should return a required field error", func() {
-				err := certRequest.FromSecretLabels(labels)
+				err := certRequest.FromSecretLabels(labels, request)
 				Expect(err.Error()).To(Equal("label 'pki.cn' is required to issue a certificate"))
 			})
 		})
@@ -91,7 +137,7 @@ var _ = Describe("Certificate request object", func() {
 			})
 
 			It("should return a required field error", func() {
-				err := certRequest.FromSecretLabels(labels)
+				err := certRequest.FromSecretLabels(labels, request)
 				Expect(err.Error()).To(Equal("label 'pki.usage' is required to issue a certificate"))
 			})
 		})
@@ -102,11 +148,123 @@ var _ = Describe("Certificate request object", func() {
 			})
 
 			It("should default to a predefined value", func() {
-				err := certRequest.FromSecretLabels(labels)
+				err := certRequest.FromSecretLabels(labels, request)
 				Expect(err).To(BeNil())
 				Expect(certRequest.Lifetime).To(Equal(driver.DefaultCertLifetime))
 			})
 		})
+
+		When("Key type and size are not specified", func() {
+			BeforeEach(func() {
+				delete(labels, "pki.key_type")
+				delete(labels, "pki.key_size")
+			})
+
+			It("should default to a RSA key of predefined length", func() {
+				err := certRequest.FromSecretLabels(labels, request)
+				Expect(err).To(BeNil())
+				Expect(certRequest.KeyType).To(Equal(driver.KeyTypeRSA))
+				Expect(certRequest.KeySize).To(Equal(driver.PrivateKeyLength))
+			})
+		})
+
+		When("Key size is not specified", func() {
+			BeforeEach(func() {
+				delete(labels, "pki.key_size")
+			})
+
+			It("should default to a predefined key size for the key type", func() {
+				err := certRequest.FromSecretLabels(labels, request)
+				Expect(err).To(BeNil())
+				Expect(certRequest.KeySize).To(Equal(driver.DefaultECDSAKey))
+			})
+		})
+	})
+
+	Describe("Evaluating templates in label values", func() {
+		BeforeEach(func() {
+			request = secrets.Request{
+				ServiceName:   "web",
+				TaskName:      "shop_web.1.abc",
+				ServiceLabels: map[string]string{"com.docker.stack.namespace": "shop"},
+			}
+		})
+
+		It("should evaluate request's fields and service labels", func() {
+			labels["pki.cn"] = `{{.ServiceName}}.{{index .ServiceLabels "com.docker.stack.namespace"}}.internal`
+			labels["pki.dns_names"] = `{{.TaskName}}.svc,{{label "com.docker.stack.namespace"}}.svc`
+
+			err := certRequest.FromSecretLabels(labels, request)
+			Expect(err).To(BeNil())
+			Expect(certRequest.CommonName).To(Equal("web.shop.internal"))
+			Expect(certRequest.DNSNames).To(Equal([]string{"shop_web.1.abc.svc", "shop.svc"}))
+		})
+
+		It("should not evaluate labels of other plugins", func() {
+			labels["other.label"] = "{{.Undefined}}"
+
+			err := certRequest.FromSecretLabels(labels, request)
+			Expect(err).To(BeNil())
+		})
+
+		It("should return an error for undefined fields", func() {
+			labels["pki.cn"] = "{{.ServiceNam}}"
+
+			err := certRequest.FromSecretLabels(labels, request)
+			Expect(err.Error()).To(ContainSubstring("error evaluating template in label 'pki.cn'"))
+			Expect(err.Error()).To(ContainSubstring("can't evaluate field ServiceNam"))
+		})
+
+		It("should return an error for service labels not set", func() {
+			labels["pki.cn"] = `{{label "com.example.team"}}`
+
+			err := certRequest.FromSecretLabels(labels, request)
+			Expect(err.Error()).To(ContainSubstring("service label com.example.team is not set"))
+		})
+
+		It("should return an error for service labels not set looked up by index", func() {
+			labels["pki.cn"] = `{{index .ServiceLabels "com.example.team"}}`
+
+			err := certRequest.FromSecretLabels(labels, request)
+			Expect(err.Error()).To(ContainSubstring(`map has no entry for key "com.example.team"`))
+		})
+
+		It("should return an error for invalid templates", func() {
+			labels["pki.cn"] = "{{.ServiceName"
+
+			err := certRequest.FromSecretLabels(labels, request)
+			Expect(err.Error()).To(ContainSubstring("error parsing template in label 'pki.cn'"))
+		})
+	})
+
+	Describe("Loading trust bundle request from label values", func() {
+		BeforeEach(func() {
+			labels = map[string]string{
+				"pki.kind": "trust",
+				"pki.ca":   "internal, partners",
+			}
+		})
+
+		It("should extract CA names", func() {
+			err := certRequest.FromSecretLabels(labels, request)
+			Expect(err).To(BeNil())
+			Expect(certRequest.Kind).To(Equal(driver.KindTrust))
+			Expect(certRequest.TrustedCAs).To(Equal([]string{"internal", "partners"}))
+		})
+
+		It("should reject formats other than PEM", func() {
+			labels["pki.format"] = "der"
+
+			err := certRequest.FromSecretLabels(labels, request)
+			Expect(err.Error()).To(Equal("trust bundles can not be issued in format: der"))
+		})
+
+		It("should reject unknown secret kinds", func() {
+			labels["pki.kind"] = "key"
+
+			err := certRequest.FromSecretLabels(labels, request)
+			Expect(err.Error()).To(Equal("unknown secret kind requested: key"))
+		})
 	})
 
 	Describe("Handling invalid label values", func() {
@@ -116,7 +274,7 @@ var _ = Describe("Certificate request object", func() {
 			})
 
 			It("should return a disallowed usage error", func() {
-				err := certRequest.FromSecretLabels(labels)
+				err := certRequest.FromSecretLabels(labels, request)
 				Expect(err.Error()).To(Equal("disallowed usage requested for certificate: not allowed"))
 			})
 		})
@@ -127,7 +285,7 @@ var _ = Describe("Certificate request object", func() {
 			})
 
 			It("should return a duration parse error", func() {
-				err := certRequest.FromSecretLabels(labels)
+				err := certRequest.FromSecretLabels(labels, request)
 				Expect(err.Error()).To(ContainSubstring("error parsing requested certificate lifetime"))
 			})
 		})
@@ -138,9 +296,194 @@ var _ = Describe("Certificate request object", func() {
 			})
 
 			It("should return an IP address parse error", func() {
-				err := certRequest.FromSecretLabels(labels)
+				err := certRequest.FromSecretLabels(labels, request)
 				Expect(err.Error()).To(Equal("error parsing IP address from: 'not an IP address'"))
 			})
 		})
+
+		When("Automatic SAN source is not known", func() {
+			BeforeEach(func() {
+				labels["pki.auto_sans"] = "service,containers"
+			})
+
+			It("should return an unknown source error", func() {
+				err := certRequest.FromSecretLabels(labels, request)
+				Expect(err.Error()).To(Equal("unknown automatic SAN source: containers"))
+			})
+		})
+
+		When("URI is not absolute", func() {
+			BeforeEach(func() {
+				labels["pki.uris"] = "https://server.test,/relative/path"
+			})
+
+			It("should return an URI error", func() {
+				err := certRequest.FromSecretLabels(labels, request)
+				Expect(err.Error()).To(Equal("URI must be absolute: '/relative/path'"))
+			})
+		})
+
+		When("SPIFFE ID is specified with URIs", func() {
+			BeforeEach(func() {
+				labels["pki.spiffe_id"] = "spiffe://example.org/web"
+			})
+
+			It("should return an error", func() {
+				err := certRequest.FromSecretLabels(labels, request)
+				Expect(err.Error()).To(Equal("labels 'pki.spiffe_id' and 'pki.uris' can not be used together"))
+			})
+		})
+
+		When("SPIFFE ID is not valid", func() {
+			BeforeEach(func() {
+				delete(labels, "pki.uris")
+			})
+
+			DescribeTable("should return a SPIFFE ID error",
+				func(id, msg string) {
+					labels["pki.spiffe_id"] = id
+
+					err := certRequest.FromSecretLabels(labels, request)
+					Expect(err).ToNot(BeNil())
+					Expect(err.Error()).To(Equal(msg))
+				},
+				Entry("wrong scheme", "https://example.org/web", "SPIFFE ID must use spiffe scheme: 'https://example.org/web'"),
+				Entry("port", "spiffe://example.org:443/web", "SPIFFE ID trust domain can not contain user info or port: 'spiffe://example.org:443/web'"),
+				Entry("uppercase trust domain", "spiffe://Example.org/web", "SPIFFE ID has invalid trust domain: 'spiffe://Example.org/web'"),
+				Entry("query", "spiffe://example.org/web?x=1", "SPIFFE ID can not contain query or fragment: 'spiffe://example.org/web?x=1'"),
+				Entry("fragment", "spiffe://example.org/web#x", "SPIFFE ID can not contain query or fragment: 'spiffe://example.org/web#x'"),
+				Entry("no path", "spiffe://example.org", "SPIFFE ID of a workload must have a path: 'spiffe://example.org'"),
+				Entry("empty segment", "spiffe://example.org/web//api", "SPIFFE ID has invalid path: 'spiffe://example.org/web//api'"),
+				Entry("dot segment", "spiffe://example.org/web/../api", "SPIFFE ID has invalid path: 'spiffe://example.org/web/../api'"),
+			)
+		})
+
+		When("Key type is not supported", func() {
+			BeforeEach(func() {
+				labels["pki.key_type"] = "dsa"
+			})
+
+			It("should return an unsupported key type error", func() {
+				err := certRequest.FromSecretLabels(labels, request)
+				Expect(err.Error()).To(Equal("unsupported key type: dsa"))
+			})
+		})
+
+		When("Key size is not supported for the key type", func() {
+			BeforeEach(func() {
+				labels["pki.key_size"] = "4096"
+			})
+
+			It("should return an unsupported key size error", func() {
+				err := certRequest.FromSecretLabels(labels, request)
+				Expect(err.Error()).To(Equal("unsupported key size for key type ecdsa: 4096"))
+			})
+		})
+
+		When("Key size is specified for Ed25519 keys", func() {
+			BeforeEach(func() {
+				labels["pki.key_type"] = "ed25519"
+				labels["pki.key_size"] = "256"
+			})
+
+			It("should return a key size error", func() {
+				err := certRequest.FromSecretLabels(labels, request)
+				Expect(err.Error()).To(Equal("key size can not be specified for key type: ed25519"))
+			})
+		})
+
+		When("Signature algorithm is not supported", func() {
+			BeforeEach(func() {
+				labels["pki.signature_algorithm"] = "MD5WithRSA"
+			})
+
+			It("should return an unsupported signature algorithm error", func() {
+				err := certRequest.FromSecretLabels(labels, request)
+				Expect(err.Error()).To(Equal("unsupported signature algorithm: MD5WithRSA"))
+			})
+		})
+
+		When("Output format is not supported", func() {
+			BeforeEach(func() {
+				labels["pki.format"] = "xml"
+			})
+
+			It("should return an unsupported format error", func() {
+				err := certRequest.FromSecretLabels(labels, request)
+				Expect(err.Error()).To(Equal("unsupported output format: xml"))
+			})
+		})
+
+		When("Passphrase secret is specified with a passphrase source", func() {
+			BeforeEach(func() {
+				labels["pki.passphrase_secret"] = "app_passphrase"
+			})
+
+			It("should return a conflicting labels error", func() {
+				err := certRequest.FromSecretLabels(labels, request)
+				Expect(err.Error()).To(Equal("labels 'pki.passphrase_secret' and 'pki.passphrase_source' can not be used together"))
+			})
+		})
+
+		When("Passphrase secret is a file", func() {
+			BeforeEach(func() {
+				delete(labels, "pki.passphrase_source")
+				labels["pki.passphrase_secret"] = "file:passphrase"
+			})
+
+			It("should return an invalid passphrase secret error", func() {
+				err := certRequest.FromSecretLabels(labels, request)
+				Expect(err.Error()).To(Equal("label 'pki.passphrase_secret' must be the name of a passphrase"))
+			})
+		})
+
+		When("Secret part is not known", func() {
+			BeforeEach(func() {
+				labels["pki.format"] = "pem"
+				labels["pki.group"] = "web"
+				labels["pki.part"] = "everything"
+			})
+
+			It("should return an unknown part error", func() {
+				err := certRequest.FromSecretLabels(labels, request)
+				Expect(err.Error()).To(Equal("unknown secret part requested: everything"))
+			})
+		})
+
+		When("Secret part is requested without a group", func() {
+			BeforeEach(func() {
+				labels["pki.format"] = "pem"
+				labels["pki.part"] = "key"
+			})
+
+			It("should return a required field error", func() {
+				err := certRequest.FromSecretLabels(labels, request)
+				Expect(err.Error()).To(Equal("label 'pki.group' is required to issue a part of certificate"))
+			})
+		})
+
+		When("Secret part is requested in a keystore format", func() {
+			BeforeEach(func() {
+				labels["pki.format"] = "pkcs12"
+				labels["pki.group"] = "web"
+				labels["pki.part"] = "cert"
+			})
+
+			It("should return a format error", func() {
+				err := certRequest.FromSecretLabels(labels, request)
+				Expect(err.Error()).To(Equal("secret parts can not be issued in format: pkcs12"))
+			})
+		})
+
+		When("Key size is not a number", func() {
+			BeforeEach(func() {
+				labels["pki.key_size"] = "large"
+			})
+
+			It("should return a key size parse error", func() {
+				err := certRequest.FromSecretLabels(labels, request)
+				Expect(err.Error()).To(ContainSubstring("error parsing requested key size"))
+			})
+		})
 	})
 })
@@ -0,0 +1,103 @@
+package driver
+
+import (
+	"context"
+	"net"
+
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/go-plugins-helpers/secrets"
+	"github.com/pkg/errors"
+)
+
+// SAN sources derived from the requesting Swarm service.
+const (
+	AutoSANService = "service"
+	AutoSANTasks   = "tasks"
+	AutoSANAliases = "aliases"
+	AutoSANVIPs    = "vips"
+)
+
+// applyAutoSANs inspects the requesting service and merges the SANs derived
+// from it with the ones requested explicitly.
+func (d Driver) applyAutoSANs(ctx context.Context, request secrets.Request, config *CertRequest) error {
+	if len(config.AutoSANs) == 0 {
+		return nil
+	}
+
+	if request.ServiceID == "" {
+		return errors.New("automatic SANs can only be derived for secrets attached to a service")
+	}
+
+	service, _, err := d.client.ServiceInspectWithRaw(ctx, request.ServiceID)
+	if err != nil {
+		return errors.Wrap(err, "error inspecting service")
+	}
+
+	dnsNames, ipAddrs := serviceSANs(service, config.AutoSANs)
+
+	for _, name := range dnsNames {
+		if !containsString(config.DNSNames, name) {
+			config.DNSNames = append(config.DNSNames, name)
+		}
+	}
+
+	for _, addr := range ipAddrs {
+		if !containsIP(config.IPAddrs, addr) {
+			config.IPAddrs = append(config.IPAddrs, addr)
+		}
+	}
+
+	return nil
+}
+
+// serviceSANs derives DNS names and IP addresses of the service from the
+// requested sources.
+func serviceSANs(service swarm.Service, sources []string) (dnsNames []string, ipAddrs []net.IP) {
+	for _, source := range sources {
+		switch source {
+		case AutoSANService:
+			dnsNames = append(dnsNames, service.Spec.Name)
+		case AutoSANTasks:
+			dnsNames = append(dnsNames, "tasks."+service.Spec.Name)
+		case AutoSANAliases:
+			// Networks in service's spec are deprecated in favor of the ones
+			// in task template, but older clients still use them.
+			for _, network := range service.Spec.TaskTemplate.Networks {
+				dnsNames = append(dnsNames, network.Aliases...)
+			}
+			for _, network := range service.Spec.Networks {
+				dnsNames = append(dnsNames, network.Aliases...)
+			}
+		case AutoSANVIPs:
+			for _, vip := range service.Endpoint.VirtualIPs {
+				if ip, _, err := net.ParseCIDR(vip.Addr); err == nil {
+					ipAddrs = append(ipAddrs, ip)
+				} else if ip := net.ParseIP(vip.Addr); ip != nil {
+					ipAddrs = append(ipAddrs, ip)
+				}
+			}
+		}
+	}
+
+	return dnsNames, ipAddrs
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsIP(addrs []net.IP, addr net.IP) bool {
+	for _, a := range addrs {
+		if a.Equal(addr) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,84 @@
+package driver_test
+
+import (
+	"crypto/x509"
+	"net"
+
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/go-plugins-helpers/secrets"
+
+	"docker-secretprovider-pki/backend"
+	"docker-secretprovider-pki/driver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Automatic SANs", func() {
+	var (
+		drv    *driver.Driver
+		docker *fakeDocker
+	)
+
+	BeforeEach(func() {
+		service := swarm.Service{ID: "service-id"}
+		service.Spec.Name = "shop_web"
+		service.Spec.TaskTemplate.Networks = []swarm.NetworkAttachmentConfig{
+			{Target: "frontend", Aliases: []string{"web", "www"}},
+		}
+		service.Spec.Networks = []swarm.NetworkAttachmentConfig{
+			{Target: "backend", Aliases: []string{"web"}},
+		}
+		service.Endpoint.VirtualIPs = []swarm.EndpointVirtualIP{
+			{NetworkID: "frontend", Addr: "10.0.0.5/24"},
+		}
+
+		docker = &fakeDocker{
+			secrets: map[string]map[string]string{
+				"web": {
+					"pki.ca":        "test",
+					"pki.cn":        "web",
+					"pki.usage":     "server",
+					"pki.dns_names": "web.example.com,www",
+					"pki.auto_sans": "service,tasks,aliases,vips",
+				},
+			},
+			services: map[string]swarm.Service{"service-id": service},
+		}
+
+		var err error
+		drv, err = driver.NewDriver(&backend.TestBackend{}, docker)
+		Expect(err).To(BeNil())
+	})
+
+	When("Secret requests SANs derived from the service", func() {
+		It("should merge derived SANs with explicit ones", func() {
+			response := drv.Get(secrets.Request{SecretName: "web", ServiceID: "service-id"})
+			Expect(response.Err).To(BeEmpty())
+
+			bundle, err := parsePKIBundle(response.Value)
+			Expect(err).To(BeNil())
+
+			leaf, err := x509.ParseCertificate(bundle.Certificate[0])
+			Expect(err).To(BeNil())
+
+			Expect(leaf.DNSNames).To(Equal([]string{"web.example.com", "www", "shop_web", "tasks.shop_web", "web"}))
+			Expect(leaf.IPAddresses).To(HaveLen(1))
+			Expect(leaf.IPAddresses[0].Equal(net.ParseIP("10.0.0.5"))).To(BeTrue())
+		})
+	})
+
+	When("Secret is not attached to a service", func() {
+		It("should return an error", func() {
+			response := drv.Get(secrets.Request{SecretName: "web"})
+			Expect(response.Err).To(ContainSubstring("automatic SANs can only be derived for secrets attached to a service"))
+		})
+	})
+
+	When("Service can not be inspected", func() {
+		It("should return an error", func() {
+			response := drv.Get(secrets.Request{SecretName: "web", ServiceID: "unknown"})
+			Expect(response.Err).To(ContainSubstring("error inspecting service"))
+		})
+	})
+})
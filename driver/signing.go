@@ -0,0 +1,84 @@
+package driver
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Signature algorithms accepted in certificate requests, keyed by lower-cased
+// name of the corresponding x509 constant.
+var signatureAlgorithms = map[string]x509.SignatureAlgorithm{
+	"sha256withrsa":    x509.SHA256WithRSA,
+	"sha384withrsa":    x509.SHA384WithRSA,
+	"sha512withrsa":    x509.SHA512WithRSA,
+	"sha256withrsapss": x509.SHA256WithRSAPSS,
+	"sha384withrsapss": x509.SHA384WithRSAPSS,
+	"sha512withrsapss": x509.SHA512WithRSAPSS,
+	"ecdsawithsha256":  x509.ECDSAWithSHA256,
+	"ecdsawithsha384":  x509.ECDSAWithSHA384,
+	"ecdsawithsha512":  x509.ECDSAWithSHA512,
+	"pureed25519":      x509.PureEd25519,
+}
+
+// ParseSignatureAlgorithm looks up a signature algorithm by its name,
+// e.g. SHA384WithRSA, ECDSAWithSHA256 or PureEd25519.
+func ParseSignatureAlgorithm(name string) (x509.SignatureAlgorithm, error) {
+	if algo, exists := signatureAlgorithms[strings.ToLower(name)]; exists {
+		return algo, nil
+	}
+
+	return x509.UnknownSignatureAlgorithm, errors.Errorf("unsupported signature algorithm: %s", name)
+}
+
+// signatureAlgorithmFor returns the signature algorithm to sign certificates
+// with the key. Requested algorithm is validated against the key, the default
+// algorithm for the key is returned when the request does not specify one.
+func signatureAlgorithmFor(key crypto.Signer, requested x509.SignatureAlgorithm) (x509.SignatureAlgorithm, error) {
+	var (
+		allowed []x509.SignatureAlgorithm
+		keyType string
+	)
+
+	switch k := key.Public().(type) {
+	case *rsa.PublicKey:
+		keyType = KeyTypeRSA
+		allowed = []x509.SignatureAlgorithm{
+			x509.SHA256WithRSA, x509.SHA384WithRSA, x509.SHA512WithRSA,
+			x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS, x509.SHA512WithRSAPSS,
+		}
+	case *ecdsa.PublicKey:
+		keyType = KeyTypeECDSA
+		// Default to a hash matching the strength of the curve.
+		switch k.Curve.Params().BitSize {
+		case 384:
+			allowed = []x509.SignatureAlgorithm{x509.ECDSAWithSHA384, x509.ECDSAWithSHA256, x509.ECDSAWithSHA512}
+		case 521:
+			allowed = []x509.SignatureAlgorithm{x509.ECDSAWithSHA512, x509.ECDSAWithSHA256, x509.ECDSAWithSHA384}
+		default:
+			allowed = []x509.SignatureAlgorithm{x509.ECDSAWithSHA256, x509.ECDSAWithSHA384, x509.ECDSAWithSHA512}
+		}
+	case ed25519.PublicKey:
+		keyType = KeyTypeEd25519
+		allowed = []x509.SignatureAlgorithm{x509.PureEd25519}
+	default:
+		return x509.UnknownSignatureAlgorithm, errors.Errorf("unsupported CA key type: %T", k)
+	}
+
+	if requested == x509.UnknownSignatureAlgorithm {
+		return allowed[0], nil
+	}
+
+	for _, algo := range allowed {
+		if algo == requested {
+			return requested, nil
+		}
+	}
+
+	return x509.UnknownSignatureAlgorithm, errors.Errorf("signature algorithm %s can not be used with %s CA key", requested, keyType)
+}
@@ -0,0 +1,105 @@
+package driver
+
+import (
+	"crypto/x509"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SPIFFEScheme is the URI scheme of SPIFFE IDs.
+const SPIFFEScheme = "spiffe"
+
+var (
+	spiffeTrustDomain = regexp.MustCompile(`^[a-z0-9._-]+$`)
+	spiffePathSegment = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+)
+
+// ParseURIs parses comma separated list of absolute URIs.
+func ParseURIs(value string) ([]*url.URL, error) {
+	var uris []*url.URL
+
+	for _, raw := range strings.Split(value, ",") {
+		uri, err := url.Parse(raw)
+		if err != nil {
+			return nil, errors.Errorf("error parsing URI from: '%s'", raw)
+		}
+
+		if !uri.IsAbs() || (uri.Host == "" && uri.Opaque == "") {
+			return nil, errors.Errorf("URI must be absolute: '%s'", raw)
+		}
+
+		uris = append(uris, uri)
+	}
+
+	return uris, nil
+}
+
+// ParseSPIFFEID parses and validates a workload's SPIFFE ID according to
+// the SPIFFE ID and X509-SVID specifications.
+func ParseSPIFFEID(value string) (*url.URL, error) {
+	id, err := url.Parse(value)
+	if err != nil {
+		return nil, errors.Errorf("error parsing SPIFFE ID from: '%s'", value)
+	}
+
+	if id.Scheme != SPIFFEScheme {
+		return nil, errors.Errorf("SPIFFE ID must use spiffe scheme: '%s'", value)
+	}
+
+	if id.User != nil || id.Port() != "" {
+		return nil, errors.Errorf("SPIFFE ID trust domain can not contain user info or port: '%s'", value)
+	}
+
+	if !spiffeTrustDomain.MatchString(id.Host) {
+		return nil, errors.Errorf("SPIFFE ID has invalid trust domain: '%s'", value)
+	}
+
+	if id.RawQuery != "" || id.ForceQuery || id.Fragment != "" || strings.Contains(value, "#") {
+		return nil, errors.Errorf("SPIFFE ID can not contain query or fragment: '%s'", value)
+	}
+
+	if id.Path == "" || id.Path == "/" {
+		return nil, errors.Errorf("SPIFFE ID of a workload must have a path: '%s'", value)
+	}
+
+	for _, segment := range strings.Split(strings.TrimPrefix(id.Path, "/"), "/") {
+		if segment == "." || segment == ".." || !spiffePathSegment.MatchString(segment) {
+			return nil, errors.Errorf("SPIFFE ID has invalid path: '%s'", value)
+		}
+	}
+
+	return id, nil
+}
+
+// caTrustDomain returns the SPIFFE trust domain configured for the CA with
+// either a SPIFFE ID or a permitted URI domain name constraint.
+func caTrustDomain(ca *x509.Certificate) string {
+	for _, uri := range ca.URIs {
+		if uri.Scheme == SPIFFEScheme {
+			return uri.Host
+		}
+	}
+
+	if len(ca.PermittedURIDomains) == 1 {
+		return ca.PermittedURIDomains[0]
+	}
+
+	return ""
+}
+
+// validateTrustDomain checks the SPIFFE ID belongs to the trust domain of
+// the CA.
+func validateTrustDomain(id *url.URL, trustDomain string) error {
+	if trustDomain == "" {
+		return errors.New("CA has no SPIFFE trust domain configured")
+	}
+
+	if id.Host != trustDomain {
+		return errors.Errorf("SPIFFE ID trust domain %s does not match CA's trust domain %s", id.Host, trustDomain)
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package driver
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+
+	"github.com/docker/go-plugins-helpers/secrets"
+	"github.com/pkg/errors"
+)
+
+// labelPrefix is the prefix of labels configuring issued certificates.
+const labelPrefix = "pki."
+
+// renderLabels evaluates the plugin's labels as Go templates with the
+// request's metadata, e.g. `{{.ServiceName}}.internal` or
+// `{{label "com.docker.stack.namespace"}}`.
+func renderLabels(labels map[string]string, request secrets.Request) (map[string]string, error) {
+	funcs := template.FuncMap{
+		// label looks up a service label, failing when it is not set.
+		"label": func(name string) (string, error) {
+			value, exists := request.ServiceLabels[name]
+			if !exists {
+				return "", errors.Errorf("service label %s is not set", name)
+			}
+
+			return value, nil
+		},
+
+		// index replaces the builtin, which ignores missingkey=error and
+		// renders labels not set as empty strings.
+		"index": func(labels map[string]string, name string) (string, error) {
+			value, exists := labels[name]
+			if !exists {
+				return "", errors.Errorf("map has no entry for key %q", name)
+			}
+
+			return value, nil
+		},
+	}
+
+	rendered := make(map[string]string, len(labels))
+
+	for name, value := range labels {
+		if !strings.HasPrefix(name, labelPrefix) || !strings.Contains(value, "{{") {
+			rendered[name] = value
+			continue
+		}
+
+		tmpl, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing template in label '%s'", name)
+		}
+
+		buf := &bytes.Buffer{}
+		if err := tmpl.Execute(buf, request); err != nil {
+			return nil, errors.Wrapf(err, "error evaluating template in label '%s'", name)
+		}
+
+		rendered[name] = buf.String()
+	}
+
+	return rendered, nil
+}
@@ -0,0 +1,78 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+
+	"github.com/pkg/errors"
+)
+
+// Secret kinds.
+const (
+	KindCertificate = "certificate"
+	KindTrust       = "trust"
+)
+
+// TrustBackend is implemented by CA backends able to return CA certificates
+// without releasing the CA's private key.
+type TrustBackend interface {
+	LoadCertificates(ctx context.Context, name string) ([]*x509.Certificate, error)
+}
+
+// loadCertificates returns the certificate chain of the CA, avoiding loading
+// the private key when the backend allows it.
+func (d Driver) loadCertificates(ctx context.Context, name string) ([]*x509.Certificate, error) {
+	if d.signer != nil && d.signer.Signs(name) {
+		backend, ok := d.signer.(TrustBackend)
+		if !ok {
+			return nil, errors.Errorf("signing backend of CA %s can not return CA certificates", name)
+		}
+
+		return backend.LoadCertificates(ctx, name)
+	}
+
+	if backend, ok := d.ca.(TrustBackend); ok {
+		return backend.LoadCertificates(ctx, name)
+	}
+
+	ca, err := d.ca.Load(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return ca.Chain, nil
+}
+
+// IssueTrustBundle creates a PEM bundle of the certificate chains of
+// requested CAs.
+func (d Driver) IssueTrustBundle(ctx context.Context, config CertRequest) ([]byte, error) {
+	bundle := &bytes.Buffer{}
+	seen := map[string]bool{}
+
+	for _, name := range config.TrustedCAs {
+		chain, err := d.loadCertificates(ctx, name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error loading CA certificates for: %s", name)
+		}
+
+		if len(chain) == 0 {
+			return nil, errors.Errorf("CA has no certificates: %s", name)
+		}
+
+		for _, cert := range chain {
+			// CAs can share parts of the chain, e.g. the root.
+			if seen[string(cert.Raw)] {
+				continue
+			}
+			seen[string(cert.Raw)] = true
+
+			if err := pem.Encode(bundle, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return bundle.Bytes(), nil
+}
@@ -0,0 +1,106 @@
+package driver_test
+
+import (
+	"context"
+	"crypto/x509"
+
+	"github.com/docker/go-plugins-helpers/secrets"
+	"github.com/pkg/errors"
+
+	"docker-secretprovider-pki/backend"
+	"docker-secretprovider-pki/driver"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// certificatesOnlyCA is a CA backend refusing to release the private key.
+type certificatesOnlyCA struct {
+	certs map[string][]*x509.Certificate
+}
+
+func (b *certificatesOnlyCA) Load(ctx context.Context, name string) (*driver.CA, error) {
+	return nil, errors.New("private key can not be released")
+}
+
+func (b *certificatesOnlyCA) LoadCertificates(ctx context.Context, name string) ([]*x509.Certificate, error) {
+	certs, exists := b.certs[name]
+	if !exists {
+		return nil, errors.Errorf("CA %s not found", name)
+	}
+
+	return certs, nil
+}
+
+var _ = Describe("Trust bundles", func() {
+	var (
+		drv    *driver.Driver
+		docker *fakeDocker
+		roots  []*x509.Certificate
+	)
+
+	BeforeEach(func() {
+		var err error
+		roots, err = (&backend.TestBackend{}).LoadCertificates(context.Background(), "test")
+		Expect(err).To(BeNil())
+
+		other, err := newGeneratedCA(mustGenerateKey())
+		Expect(err).To(BeNil())
+
+		docker = &fakeDocker{secrets: map[string]map[string]string{
+			"trust": {
+				"pki.kind": "trust",
+				"pki.ca":   "internal,partners",
+			},
+		}}
+
+		drv, err = driver.NewDriver(&certificatesOnlyCA{certs: map[string][]*x509.Certificate{
+			"internal": roots,
+			"partners": {other.cert, roots[1]},
+		}}, docker)
+		Expect(err).To(BeNil())
+	})
+
+	When("Trust bundle is requested for several CAs", func() {
+		It("should return the certificate chains of all CAs without duplicates", func() {
+			response := drv.Get(secrets.Request{SecretName: "trust"})
+			Expect(response.Err).To(BeEmpty())
+
+			bundle, err := parsePKIBundle(response.Value)
+			Expect(err).To(BeNil())
+
+			Expect(bundle.PrivateKey).To(BeNil())
+			Expect(bundle.Certificate).To(HaveLen(3))
+		})
+	})
+
+	When("Requested CA does not exist", func() {
+		BeforeEach(func() {
+			docker.secrets["trust"]["pki.ca"] = "internal,unknown"
+		})
+
+		It("should return an error", func() {
+			response := drv.Get(secrets.Request{SecretName: "trust"})
+			Expect(response.Err).To(ContainSubstring("error loading CA certificates for: unknown"))
+		})
+	})
+
+	When("Backend does not support loading certificates only", func() {
+		BeforeEach(func() {
+			var err error
+			drv, err = driver.NewDriver(&generatedCA{ca: &driver.CA{Chain: roots[:1]}}, docker)
+			Expect(err).To(BeNil())
+		})
+
+		It("should return the certificates of the CA bundle", func() {
+			docker.secrets["trust"]["pki.ca"] = "internal"
+
+			response := drv.Get(secrets.Request{SecretName: "trust"})
+			Expect(response.Err).To(BeEmpty())
+
+			bundle, err := parsePKIBundle(response.Value)
+			Expect(err).To(BeNil())
+			Expect(bundle.Certificate).To(HaveLen(1))
+		})
+	})
+})
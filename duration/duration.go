@@ -0,0 +1,30 @@
+// Package duration implements durations configured in JSON files.
+package duration
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Duration is a time.Duration unmarshaled from Go duration strings.
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON parses the duration from a string like `24h`.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return errors.Wrap(err, "duration must be a string")
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+
+	d.Duration = parsed
+	return nil
+}
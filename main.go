@@ -1,19 +1,35 @@
 package main
 
 import (
+	"expvar"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/docker/docker/client"
 	"github.com/docker/go-plugins-helpers/secrets"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 
+	"docker-secretprovider-pki/approval"
+	"docker-secretprovider-pki/authorizer"
 	"docker-secretprovider-pki/backend"
 	"docker-secretprovider-pki/driver"
+	"docker-secretprovider-pki/policy"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "pki: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	logger, _ := zap.NewDevelopment()
 	zap.ReplaceGlobals(logger)
 
@@ -25,27 +41,124 @@ func main() {
 		zap.S().Fatalf("pki: error creating docker client: %v", err)
 	}
 
-	var ca driver.CABackend
-	switch os.Getenv("BACKEND") {
-	case "secrethub":
-		ca, err = backend.NewSecrethubBackend()
-	case "test":
-		ca, err = backend.NewTestBackend()
-	default:
-		err = errors.New("backend not configured. Use `docker plugin set <plugin alias> BACKEND=<value>`")
-	}
+	// CA_PASSPHRASE is read once for all backends, which hold the passphrase
+	// only.
+	passphrase := []byte(os.Getenv("CA_PASSPHRASE"))
+	os.Unsetenv("CA_PASSPHRASE")
 
+	ca, err := newBackend(os.Getenv("BACKEND"), nil, passphrase)
+	if err == nil {
+		ca, err = newCachingBackend(ca)
+	}
 	if err != nil {
 		zap.S().Fatalf("pki: error initializing CA backend: %s", err)
 	}
 
-	drv, err := driver.NewDriver(ca, dockerClient)
+	var opts []driver.Option
+
+	vaultFile := filepath.Join(driver.DefaultConfigDir, backend.VaultFile)
+	if _, err := os.Stat(vaultFile); err == nil {
+		config, err := backend.LoadVaultConfig(vaultFile)
+		if err != nil {
+			zap.S().Fatalf("pki: error loading Vault configuration: %s", err)
+		}
+
+		if len(config.PKI) > 0 {
+			signer, err := backend.NewVaultSigner(config)
+			if err != nil {
+				zap.S().Fatalf("pki: error initializing Vault PKI signing backend: %s", err)
+			}
+
+			opts = append(opts, driver.WithSigningBackend(signer))
+		}
+	}
+
+	if os.Getenv("IDENTITY_URI") != "" || os.Getenv("IDENTITY_EXTENSION_OID") != "" {
+		opts = append(opts, driver.WithIdentity(driver.IdentityConfig{
+			URITemplate:  os.Getenv("IDENTITY_URI"),
+			ExtensionOID: os.Getenv("IDENTITY_EXTENSION_OID"),
+		}))
+	}
+
+	policyFile := filepath.Join(driver.DefaultConfigDir, policy.DefaultFile)
+	if _, err := os.Stat(policyFile); err == nil {
+		p, err := policy.Load(policyFile)
+		if err != nil {
+			zap.S().Fatalf("pki: error loading issuance policy: %s", err)
+		}
+
+		opts = append(opts, driver.WithPolicy(p))
+	}
+
+	authorizerFile := filepath.Join(driver.DefaultConfigDir, authorizer.DefaultFile)
+	if _, err := os.Stat(authorizerFile); err == nil {
+		a, err := authorizer.Load(authorizerFile)
+		if err != nil {
+			zap.S().Fatalf("pki: error loading external authorizers: %s", err)
+		}
+
+		opts = append(opts, driver.WithAuthorizer(a))
+	}
+
+	keyPoolFile := filepath.Join(driver.DefaultConfigDir, driver.KeyPoolFile)
+	if _, err := os.Stat(keyPoolFile); err == nil {
+		config, err := driver.LoadKeyPoolConfig(keyPoolFile)
+		if err != nil {
+			zap.S().Fatalf("pki: error loading key pool configuration: %s", err)
+		}
+
+		pool, err := driver.NewKeyPool(config)
+		if err != nil {
+			zap.S().Fatalf("pki: error initializing key pool: %s", err)
+		}
+
+		expvar.Publish("keypool", expvar.Func(func() interface{} { return pool.Stats() }))
+
+		opts = append(opts, driver.WithKeyPool(pool))
+	}
+
+	approvalFile := filepath.Join(driver.DefaultConfigDir, approval.DefaultFile)
+	if _, err := os.Stat(approvalFile); err == nil {
+		store := approval.NewStore(filepath.Join(driver.DefaultConfigDir, approval.DefaultDir))
+
+		gate, err := approval.Load(approvalFile, store)
+		if err != nil {
+			zap.S().Fatalf("pki: error loading approval configuration: %s", err)
+		}
+
+		opts = append(opts, driver.WithApprovalGate(gate))
+	}
+
+	drv, err := driver.NewDriver(ca, dockerClient, opts...)
 	if err != nil {
 		zap.S().Fatalf("pki: error initializing PKI driver: %s", err)
 	}
 
 	handler := secrets.NewHandler(drv)
+	handler.HandleFunc("/debug/vars", expvar.Handler().ServeHTTP)
 	if err := handler.ServeUnix("plugin", 0); err != nil {
 		zap.S().Fatalf("pki: %s", err)
 	}
 }
+
+// caPassphrase returns the passphrase of CA private keys, read from the file
+// in CA_PASSPHRASE_FILE (relative to the config directory) or the passphrase
+// read from CA_PASSPHRASE.
+func caPassphrase(passphrase []byte) ([]byte, error) {
+	if filename := os.Getenv("CA_PASSPHRASE_FILE"); filename != "" {
+		return readPassphraseFile(filename)
+	}
+
+	return passphrase, nil
+}
+
+// readPassphraseFile reads the passphrase of CA private keys from the file,
+// relative to the config directory.
+func readPassphraseFile(filename string) ([]byte, error) {
+	raw, err := ioutil.ReadFile(configPath(filename))
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading CA passphrase file")
+	}
+
+	return []byte(strings.TrimRight(string(raw), "\r\n")), nil
+}
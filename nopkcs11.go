@@ -0,0 +1,17 @@
+//go:build !pkcs11
+// +build !pkcs11
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"docker-secretprovider-pki/driver"
+)
+
+// newPKCS11Backend fails as the plugin was built without PKCS#11 support.
+func newPKCS11Backend(settings json.RawMessage) (driver.CABackend, error) {
+	return nil, errors.New("plugin was built without PKCS#11 support, rebuild it with `-tags pkcs11`")
+}
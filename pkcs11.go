@@ -0,0 +1,28 @@
+//go:build pkcs11
+// +build pkcs11
+
+package main
+
+import (
+	"encoding/json"
+
+	"docker-secretprovider-pki/backend"
+	"docker-secretprovider-pki/driver"
+)
+
+// newPKCS11Backend creates the PKCS#11 backend configured by the settings,
+// or by its configuration file in the config directory.
+func newPKCS11Backend(settings json.RawMessage) (driver.CABackend, error) {
+	var config backend.PKCS11Config
+	var err error
+	if settings == nil {
+		config, err = backend.LoadPKCS11Config(configPath(backend.PKCS11File))
+	} else {
+		config, err = backend.ParsePKCS11Config(settings, driver.DefaultConfigDir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return backend.NewPKCS11Backend(config)
+}
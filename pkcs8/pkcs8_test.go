@@ -0,0 +1,62 @@
+package pkcs8_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+
+	"docker-secretprovider-pki/pkcs8"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Encrypted PKCS#8 private keys", func() {
+	var der []byte
+
+	BeforeEach(func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).To(BeNil())
+
+		der, err = x509.MarshalPKCS8PrivateKey(key)
+		Expect(err).To(BeNil())
+	})
+
+	When("Private key is encrypted", func() {
+		var encrypted []byte
+
+		BeforeEach(func() {
+			var err error
+			encrypted, err = pkcs8.Encrypt(der, []byte("passphrase"))
+			Expect(err).To(BeNil())
+		})
+
+		It("should not contain the plaintext key", func() {
+			Expect(encrypted).ToNot(ContainSubstring(string(der)))
+		})
+
+		It("should decrypt with the same passphrase", func() {
+			decrypted, err := pkcs8.Decrypt(encrypted, []byte("passphrase"))
+			Expect(err).To(BeNil())
+			Expect(decrypted).To(Equal(der))
+		})
+
+		It("should not recover the key with a different passphrase", func() {
+			// Padding of a wrongly decrypted key can be valid by chance,
+			// in which case the garbage fails to parse as a key instead.
+			decrypted, err := pkcs8.Decrypt(encrypted, []byte("not the passphrase"))
+			if err == nil {
+				_, err = x509.ParsePKCS8PrivateKey(decrypted)
+			}
+			Expect(err).ToNot(BeNil())
+		})
+	})
+
+	When("Data is not an encrypted private key", func() {
+		It("should return a parse error", func() {
+			_, err := pkcs8.Decrypt(der, []byte("passphrase"))
+			Expect(err).ToNot(BeNil())
+		})
+	})
+})
@@ -0,0 +1,13 @@
+package pkcs8_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestPKCS8(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "PKCS#8 encryption suite")
+}
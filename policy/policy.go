@@ -0,0 +1,330 @@
+// Package policy implements declarative per-CA issuance policies.
+package policy
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/docker/go-plugins-helpers/secrets"
+	"github.com/pkg/errors"
+
+	"docker-secretprovider-pki/driver"
+	"docker-secretprovider-pki/duration"
+)
+
+// DefaultFile is the name of the policy file in plugin's config directory.
+const DefaultFile = "policy.json"
+
+// CAPolicy restricts certificates issued by a CA. Lists left unspecified
+// do not restrict the request, empty lists deny everything. URI SANs,
+// including SPIFFE IDs, are denied without allowed URIs when the names of
+// certificates are restricted otherwise.
+type CAPolicy struct {
+	AllowedCommonNames []string          `json:"allowed_common_names"`
+	AllowedDomains     []string          `json:"allowed_domains"`
+	AllowedIPRanges    []string          `json:"allowed_ip_ranges"`
+	AllowedURIs        []string          `json:"allowed_uris"`
+	AllowedUsages      []string          `json:"allowed_usages"`
+	AllowedKeyTypes    []string          `json:"allowed_key_types"`
+	MinLifetime        duration.Duration `json:"min_lifetime"`
+	MaxLifetime        duration.Duration `json:"max_lifetime"`
+
+	// Requesters restrict the services allowed to use the CA.
+	Requesters []*RequesterRule `json:"requesters"`
+
+	ipRanges []*net.IPNet
+}
+
+// keyTypes are the key types of allowed_key_types.
+var keyTypes = map[string]bool{
+	driver.KeyTypeRSA:     true,
+	driver.KeyTypeECDSA:   true,
+	driver.KeyTypeEd25519: true,
+}
+
+// Policy holds issuance policies of CAs. Requests for CAs without a policy
+// are denied.
+type Policy struct {
+	CAs map[string]*CAPolicy `json:"cas"`
+}
+
+// Load reads the policy from a JSON file.
+func Load(filename string) (*Policy, error) {
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading policy file")
+	}
+
+	return Parse(raw)
+}
+
+// Parse parses and validates a JSON encoded policy.
+func Parse(raw []byte) (*Policy, error) {
+	p := &Policy{}
+	if err := json.Unmarshal(raw, p); err != nil {
+		return nil, errors.Wrap(err, "error parsing policy")
+	}
+
+	for name, ca := range p.CAs {
+		if err := ca.compile(); err != nil {
+			return nil, errors.Wrapf(err, "invalid policy for CA %s", name)
+		}
+	}
+
+	return p, nil
+}
+
+// ParseCAPolicy parses and validates the JSON encoded policy of a single CA.
+func ParseCAPolicy(raw []byte) (*CAPolicy, error) {
+	p := &CAPolicy{}
+	if err := json.Unmarshal(raw, p); err != nil {
+		return nil, errors.Wrap(err, "error parsing CA policy")
+	}
+
+	if err := p.compile(); err != nil {
+		return nil, errors.Wrap(err, "invalid CA policy")
+	}
+
+	return p, nil
+}
+
+func (p *CAPolicy) compile() error {
+	for _, cidr := range p.AllowedIPRanges {
+		_, ipRange, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return errors.Errorf("error parsing IP range: %s", cidr)
+		}
+
+		p.ipRanges = append(p.ipRanges, ipRange)
+	}
+
+	for _, usage := range p.AllowedUsages {
+		if _, exists := usages[usage]; !exists {
+			return errors.Errorf("unknown usage: %s", usage)
+		}
+	}
+
+	for _, keyType := range p.AllowedKeyTypes {
+		if !keyTypes[keyType] {
+			return errors.Errorf("unknown key type: %s", keyType)
+		}
+	}
+
+	for _, globs := range [][]string{p.AllowedCommonNames, p.AllowedDomains} {
+		for _, glob := range globs {
+			if _, err := path.Match(domainPattern(glob), ""); err != nil {
+				return errors.Errorf("error parsing glob: %s", glob)
+			}
+		}
+	}
+
+	for _, glob := range p.AllowedURIs {
+		if _, err := path.Match(glob, ""); err != nil {
+			return errors.Errorf("error parsing glob: %s", glob)
+		}
+	}
+
+	if p.MaxLifetime.Duration > 0 && p.MinLifetime.Duration > p.MaxLifetime.Duration {
+		return errors.New("minimum lifetime exceeds maximum lifetime")
+	}
+
+	for _, rule := range p.Requesters {
+		if err := rule.compile(); err != nil {
+			return err
+		}
+
+		for _, glob := range rule.AllowedDomains {
+			if _, err := path.Match(domainPattern(glob), ""); err != nil {
+				return errors.Errorf("error parsing glob: %s", glob)
+			}
+		}
+
+		for _, glob := range rule.AllowedURIs {
+			if _, err := path.Match(glob, ""); err != nil {
+				return errors.Errorf("error parsing glob: %s", glob)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Check verifies the certificate request against the policy of its CA.
+func (p *Policy) Check(request secrets.Request, config driver.CertRequest) error {
+	ca, exists := p.CAs[config.CAName]
+	if !exists {
+		return errors.Errorf("no policy defined for CA %s", config.CAName)
+	}
+
+	return ca.Check(request, config)
+}
+
+// Check verifies the certificate request against the CA's policy.
+func (p *CAPolicy) Check(request secrets.Request, config driver.CertRequest) error {
+	if err := p.checkRequester(request, config); err != nil {
+		return err
+	}
+
+	return p.check(config)
+}
+
+func (p *CAPolicy) check(config driver.CertRequest) error {
+	// Without allowed common names, common names are restricted to the
+	// allowed domains like DNS names.
+	commonNames := p.AllowedCommonNames
+	if commonNames == nil {
+		commonNames = p.AllowedDomains
+	}
+
+	if commonNames != nil && !matchesAny(commonNames, config.CommonName) {
+		return errors.Errorf("common name %q is not allowed for CA %s", config.CommonName, config.CAName)
+	}
+
+	if p.AllowedDomains != nil {
+		for _, name := range config.DNSNames {
+			if !matchesAny(p.AllowedDomains, name) {
+				return errors.Errorf("DNS name %q is not allowed for CA %s", name, config.CAName)
+			}
+		}
+	}
+
+	if p.AllowedIPRanges != nil {
+		for _, addr := range config.IPAddrs {
+			if !containedInAny(p.ipRanges, addr) {
+				return errors.Errorf("IP address %s is not allowed for CA %s", addr, config.CAName)
+			}
+		}
+	}
+
+	if err := p.checkURIs(config); err != nil {
+		return err
+	}
+
+	if p.AllowedUsages != nil {
+		for _, usage := range config.Usage {
+			if !usageAllowed(p.AllowedUsages, usage) {
+				return errors.Errorf("usage %s is not allowed for CA %s", usageName(usage), config.CAName)
+			}
+		}
+	}
+
+	if p.AllowedKeyTypes != nil && !containsString(p.AllowedKeyTypes, config.KeyType) {
+		return errors.Errorf("key type %s is not allowed for CA %s", config.KeyType, config.CAName)
+	}
+
+	if p.MinLifetime.Duration > 0 && config.Lifetime < p.MinLifetime.Duration {
+		return errors.Errorf("lifetime %s is shorter than allowed minimum %s for CA %s", config.Lifetime, p.MinLifetime.Duration, config.CAName)
+	}
+
+	if p.MaxLifetime.Duration > 0 && config.Lifetime > p.MaxLifetime.Duration {
+		return errors.Errorf("lifetime %s exceeds allowed maximum %s for CA %s", config.Lifetime, p.MaxLifetime.Duration, config.CAName)
+	}
+
+	return nil
+}
+
+// checkURIs verifies the URI SANs of the request. Without allowed URIs, they
+// are denied when other names are restricted, so that URIs don't bypass the
+// policy.
+func (p *CAPolicy) checkURIs(config driver.CertRequest) error {
+	if p.AllowedURIs == nil && p.AllowedCommonNames == nil && p.AllowedDomains == nil && p.AllowedIPRanges == nil {
+		return nil
+	}
+
+	for _, uri := range requestURIs(config) {
+		if !matchesAnyURI(p.AllowedURIs, uri.String()) {
+			return errors.Errorf("URI %q is not allowed for CA %s", uri, config.CAName)
+		}
+	}
+
+	return nil
+}
+
+// requestURIs returns the URI SANs of the request including its SPIFFE ID.
+func requestURIs(config driver.CertRequest) []*url.URL {
+	if config.SPIFFEID == nil {
+		return config.URIs
+	}
+
+	return append(append([]*url.URL{}, config.URIs...), config.SPIFFEID)
+}
+
+// matchesAnyURI reports whether the URI matches any of the globs, where `*`
+// matches within a single path segment.
+func matchesAnyURI(globs []string, uri string) bool {
+	for _, glob := range globs {
+		if matched, _ := path.Match(glob, uri); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// domainPattern converts a domain glob to a path pattern, so that `*`
+// matches exactly one label of the domain name.
+func domainPattern(glob string) string {
+	return strings.Replace(strings.ToLower(glob), ".", "/", -1)
+}
+
+func matchesAny(globs []string, name string) bool {
+	for _, glob := range globs {
+		if matched, _ := path.Match(domainPattern(glob), domainPattern(name)); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containedInAny(ranges []*net.IPNet, addr net.IP) bool {
+	for _, r := range ranges {
+		if r.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// usages maps usage names of the policy to extended key usages.
+var usages = map[string]x509.ExtKeyUsage{
+	"server": x509.ExtKeyUsageServerAuth,
+	"client": x509.ExtKeyUsageClientAuth,
+}
+
+func usageAllowed(allowed []string, usage x509.ExtKeyUsage) bool {
+	for _, name := range allowed {
+		if usages[name] == usage {
+			return true
+		}
+	}
+
+	return false
+}
+
+func usageName(usage x509.ExtKeyUsage) string {
+	for name, u := range usages {
+		if u == usage {
+			return name
+		}
+	}
+
+	return fmt.Sprintf("%d", usage)
+}
@@ -0,0 +1,166 @@
+package policy_test
+
+import (
+	"crypto/x509"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/secrets"
+
+	"docker-secretprovider-pki/driver"
+	"docker-secretprovider-pki/policy"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Issuance policy", func() {
+	var (
+		p      *policy.Policy
+		config driver.CertRequest
+	)
+
+	BeforeEach(func() {
+		var err error
+		p, err = policy.Parse([]byte(`{
+			"cas": {
+				"internal": {
+					"allowed_common_names": ["*.internal"],
+					"allowed_domains": ["*.internal", "internal"],
+					"allowed_ip_ranges": ["10.0.0.0/8"],
+					"allowed_uris": ["spiffe://internal/service/*"],
+					"allowed_usages": ["server"],
+					"allowed_key_types": ["ecdsa"],
+					"min_lifetime": "1h",
+					"max_lifetime": "72h"
+				},
+				"open": {},
+				"names": {"allowed_domains": ["*.internal"]}
+			}
+		}`))
+		Expect(err).To(BeNil())
+
+		config = driver.CertRequest{
+			CAName:     "internal",
+			CommonName: "web.internal",
+			DNSNames:   []string{"web.internal", "internal"},
+			IPAddrs:    []net.IP{net.ParseIP("10.1.2.3")},
+			URIs:       []*url.URL{{Scheme: "spiffe", Host: "internal", Path: "/service/web"}},
+			Usage:      []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			KeyType:    driver.KeyTypeECDSA,
+			Lifetime:   24 * time.Hour,
+		}
+	})
+
+	check := func() error {
+		return p.Check(secrets.Request{}, config)
+	}
+
+	When("Request conforms to the policy", func() {
+		It("should allow the request", func() {
+			Expect(check()).To(Succeed())
+		})
+	})
+
+	When("CA has no policy", func() {
+		It("should deny the request", func() {
+			config.CAName = "unknown"
+			Expect(check().Error()).To(Equal("no policy defined for CA unknown"))
+		})
+	})
+
+	When("CA policy has no restrictions", func() {
+		It("should allow the request", func() {
+			config.CAName = "open"
+			config.DNSNames = []string{"anything.example.com"}
+			Expect(check()).To(Succeed())
+		})
+	})
+
+	When("CA policy restricts names but not URIs", func() {
+		BeforeEach(func() {
+			config.CAName = "names"
+			config.IPAddrs = nil
+			config.DNSNames = []string{"web.internal"}
+		})
+
+		It("should deny URI SANs", func() {
+			Expect(check().Error()).To(Equal(`URI "spiffe://internal/service/web" is not allowed for CA names`))
+		})
+
+		It("should allow requests without URI SANs", func() {
+			config.URIs = nil
+			Expect(check()).To(Succeed())
+		})
+
+		It("should deny common names outside the allowed domains", func() {
+			config.URIs = nil
+			config.DNSNames = nil
+			config.CommonName = "www.bank.com"
+			Expect(check().Error()).To(Equal(`common name "www.bank.com" is not allowed for CA names`))
+		})
+	})
+
+	When("Request violates the policy", func() {
+		DescribeTable("should deny the request",
+			func(modify func(), msg string) {
+				modify()
+
+				err := check()
+				Expect(err).ToNot(BeNil())
+				Expect(err.Error()).To(Equal(msg))
+			},
+			Entry("common name not matching globs",
+				func() { config.CommonName = "web.example.com" },
+				`common name "web.example.com" is not allowed for CA internal`),
+			Entry("DNS name with more labels than wildcards",
+				func() { config.DNSNames = []string{"api.web.internal"} },
+				`DNS name "api.web.internal" is not allowed for CA internal`),
+			Entry("IP address outside allowed ranges",
+				func() { config.IPAddrs = []net.IP{net.ParseIP("192.168.0.1")} },
+				"IP address 192.168.0.1 is not allowed for CA internal"),
+			Entry("URI not matching globs",
+				func() { config.URIs = []*url.URL{{Scheme: "spiffe", Host: "internal", Path: "/service/web/admin"}} },
+				`URI "spiffe://internal/service/web/admin" is not allowed for CA internal`),
+			Entry("SPIFFE ID not matching globs",
+				func() { config.SPIFFEID = &url.URL{Scheme: "spiffe", Host: "external", Path: "/service/web"} },
+				`URI "spiffe://external/service/web" is not allowed for CA internal`),
+			Entry("usage not allowed",
+				func() { config.Usage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth} },
+				"usage client is not allowed for CA internal"),
+			Entry("key type not allowed",
+				func() { config.KeyType = driver.KeyTypeRSA },
+				"key type rsa is not allowed for CA internal"),
+			Entry("lifetime below minimum",
+				func() { config.Lifetime = time.Minute },
+				"lifetime 1m0s is shorter than allowed minimum 1h0m0s for CA internal"),
+			Entry("lifetime above maximum",
+				func() { config.Lifetime = 100 * time.Hour },
+				"lifetime 100h0m0s exceeds allowed maximum 72h0m0s for CA internal"),
+		)
+	})
+
+	When("Policy is not valid", func() {
+		It("should return a parse error for invalid IP ranges", func() {
+			_, err := policy.Parse([]byte(`{"cas": {"internal": {"allowed_ip_ranges": ["10.0.0.0"]}}}`))
+			Expect(err.Error()).To(Equal("invalid policy for CA internal: error parsing IP range: 10.0.0.0"))
+		})
+
+		It("should return a parse error for unknown usages", func() {
+			_, err := policy.Parse([]byte(`{"cas": {"internal": {"allowed_usages": ["signing"]}}}`))
+			Expect(err.Error()).To(Equal("invalid policy for CA internal: unknown usage: signing"))
+		})
+
+		It("should return a parse error for unknown key types", func() {
+			_, err := policy.Parse([]byte(`{"cas": {"internal": {"allowed_key_types": ["rsa2048"]}}}`))
+			Expect(err.Error()).To(Equal("invalid policy for CA internal: unknown key type: rsa2048"))
+		})
+
+		It("should return a parse error for invalid durations", func() {
+			_, err := policy.Parse([]byte(`{"cas": {"internal": {"max_lifetime": "a day"}}}`))
+			Expect(err).ToNot(BeNil())
+		})
+	})
+})
@@ -0,0 +1,163 @@
+package policy
+
+import (
+	"net"
+	"regexp"
+
+	"github.com/docker/go-plugins-helpers/secrets"
+	"github.com/pkg/errors"
+
+	"docker-secretprovider-pki/driver"
+)
+
+// Requester rule actions.
+const (
+	ActionAllow = "allow"
+	ActionDeny  = "deny"
+)
+
+// RequesterRule matches services requesting certificates from a CA. All
+// conditions specified must match for the rule to apply.
+type RequesterRule struct {
+	Name   string `json:"name"`
+	Action string `json:"action"`
+
+	ServiceNames []string          `json:"service_names"`
+	ServiceRegex string            `json:"service_regex"`
+	Labels       map[string]string `json:"service_labels"`
+	LabelRegex   map[string]string `json:"service_label_regex"`
+
+	// AllowedDomains, AllowedIPRanges and AllowedURIs restrict the names
+	// the requester can request, in addition to the CA's policy. Once a
+	// rule restricts any names, names of kinds left unspecified are denied.
+	AllowedDomains  []string `json:"allowed_domains"`
+	AllowedIPRanges []string `json:"allowed_ip_ranges"`
+	AllowedURIs     []string `json:"allowed_uris"`
+
+	serviceRegex *regexp.Regexp
+	labelRegex   map[string]*regexp.Regexp
+	ipRanges     []*net.IPNet
+}
+
+func (r *RequesterRule) compile() error {
+	if r.Name == "" {
+		return errors.New("requester rule must have a name")
+	}
+
+	switch r.Action {
+	case "":
+		r.Action = ActionAllow
+	case ActionAllow, ActionDeny:
+	default:
+		return errors.Errorf("unknown action of requester rule %s: %s", r.Name, r.Action)
+	}
+
+	if r.ServiceRegex != "" {
+		re, err := regexp.Compile(r.ServiceRegex)
+		if err != nil {
+			return errors.Wrapf(err, "error parsing service regex of requester rule %s", r.Name)
+		}
+
+		r.serviceRegex = re
+	}
+
+	r.labelRegex = map[string]*regexp.Regexp{}
+	for label, expr := range r.LabelRegex {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return errors.Wrapf(err, "error parsing label regex of requester rule %s", r.Name)
+		}
+
+		r.labelRegex[label] = re
+	}
+
+	for _, cidr := range r.AllowedIPRanges {
+		_, ipRange, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return errors.Errorf("error parsing IP range of requester rule %s: %s", r.Name, cidr)
+		}
+
+		r.ipRanges = append(r.ipRanges, ipRange)
+	}
+
+	return nil
+}
+
+// matches reports whether the rule applies to the requesting service.
+func (r *RequesterRule) matches(request secrets.Request) bool {
+	if r.ServiceNames != nil && !containsString(r.ServiceNames, request.ServiceName) {
+		return false
+	}
+
+	if r.serviceRegex != nil && !r.serviceRegex.MatchString(request.ServiceName) {
+		return false
+	}
+
+	for label, value := range r.Labels {
+		if actual, exists := request.ServiceLabels[label]; !exists || actual != value {
+			return false
+		}
+	}
+
+	for label, re := range r.labelRegex {
+		if actual, exists := request.ServiceLabels[label]; !exists || !re.MatchString(actual) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkRequester authorizes the requesting service with the first matching
+// requester rule of the CA.
+func (p *CAPolicy) checkRequester(request secrets.Request, config driver.CertRequest) error {
+	if p.Requesters == nil {
+		return nil
+	}
+
+	for _, rule := range p.Requesters {
+		if !rule.matches(request) {
+			continue
+		}
+
+		if rule.Action == ActionDeny {
+			return errors.Errorf("service %s is denied for CA %s by requester rule %s", request.ServiceName, config.CAName, rule.Name)
+		}
+
+		return rule.checkNames(config)
+	}
+
+	return errors.Errorf("service %s is not allowed to use CA %s, no requester rule matched", request.ServiceName, config.CAName)
+}
+
+// checkNames verifies the names of the request against the rule's
+// restrictions. Rules without restrictions allow any names.
+func (r *RequesterRule) checkNames(config driver.CertRequest) error {
+	if r.AllowedDomains == nil && r.AllowedIPRanges == nil && r.AllowedURIs == nil {
+		return nil
+	}
+
+	if !matchesAny(r.AllowedDomains, config.CommonName) {
+		return errors.Errorf("common name %q is not allowed for CA %s by requester rule %s", config.CommonName, config.CAName, r.Name)
+	}
+
+	for _, name := range config.DNSNames {
+		if !matchesAny(r.AllowedDomains, name) {
+			return errors.Errorf("DNS name %q is not allowed for CA %s by requester rule %s", name, config.CAName, r.Name)
+		}
+	}
+
+	for _, addr := range config.IPAddrs {
+		if !containedInAny(r.ipRanges, addr) {
+			return errors.Errorf("IP address %s is not allowed for CA %s by requester rule %s", addr, config.CAName, r.Name)
+		}
+	}
+
+	for _, uri := range requestURIs(config) {
+		if !matchesAnyURI(r.AllowedURIs, uri.String()) {
+			return errors.Errorf("URI %q is not allowed for CA %s by requester rule %s", uri, config.CAName, r.Name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package policy_test
+
+import (
+	"net"
+	"net/url"
+
+	"github.com/docker/go-plugins-helpers/secrets"
+
+	"docker-secretprovider-pki/driver"
+	"docker-secretprovider-pki/policy"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Requester rules", func() {
+	var (
+		p       *policy.Policy
+		config  driver.CertRequest
+		request secrets.Request
+	)
+
+	BeforeEach(func() {
+		var err error
+		p, err = policy.Parse([]byte(`{
+			"cas": {
+				"production": {
+					"requesters": [
+						{
+							"name": "no-debug",
+							"action": "deny",
+							"service_regex": "_debug$"
+						},
+						{
+							"name": "shop-stack",
+							"service_labels": {"com.docker.stack.namespace": "shop"},
+							"allowed_domains": ["*.shop.internal"],
+							"allowed_uris": ["spiffe://prod/shop/*"]
+						},
+						{
+							"name": "blog-stack",
+							"service_labels": {"com.docker.stack.namespace": "blog"},
+							"allowed_domains": ["*.blog.internal"],
+							"allowed_uris": ["spiffe://prod/blog/*"]
+						},
+						{
+							"name": "monitoring",
+							"service_names": ["prometheus"]
+						},
+						{
+							"name": "teams",
+							"service_label_regex": {"com.example.team": "^(payments|billing)$"}
+						}
+					]
+				}
+			}
+		}`))
+		Expect(err).To(BeNil())
+
+		config = driver.CertRequest{
+			CAName:     "production",
+			CommonName: "web.shop.internal",
+			DNSNames:   []string{"web.shop.internal"},
+		}
+
+		request = secrets.Request{
+			ServiceName:   "shop_web",
+			ServiceLabels: map[string]string{"com.docker.stack.namespace": "shop"},
+		}
+	})
+
+	check := func() error {
+		return p.Check(request, config)
+	}
+
+	When("Service matches a rule by its labels", func() {
+		It("should allow names in the rule's name space", func() {
+			Expect(check()).To(Succeed())
+		})
+
+		It("should deny names outside the rule's name space", func() {
+			config.DNSNames = []string{"web.billing.internal"}
+			Expect(check().Error()).To(Equal(`DNS name "web.billing.internal" is not allowed for CA production by requester rule shop-stack`))
+		})
+
+		It("should allow SPIFFE IDs of the rule", func() {
+			config.SPIFFEID = &url.URL{Scheme: "spiffe", Host: "prod", Path: "/shop/web"}
+			Expect(check()).To(Succeed())
+		})
+
+		It("should deny SPIFFE IDs of other stacks", func() {
+			config.SPIFFEID = &url.URL{Scheme: "spiffe", Host: "prod", Path: "/blog/web"}
+			Expect(check().Error()).To(Equal(`URI "spiffe://prod/blog/web" is not allowed for CA production by requester rule shop-stack`))
+		})
+
+		It("should deny IP addresses not allowed by the rule", func() {
+			config.IPAddrs = []net.IP{net.ParseIP("10.0.0.1")}
+			Expect(check().Error()).To(Equal("IP address 10.0.0.1 is not allowed for CA production by requester rule shop-stack"))
+		})
+	})
+
+	When("Service matches a deny rule", func() {
+		It("should deny the request naming the rule", func() {
+			request.ServiceName = "shop_debug"
+			Expect(check().Error()).To(Equal("service shop_debug is denied for CA production by requester rule no-debug"))
+		})
+	})
+
+	When("Service matches a rule by its name", func() {
+		It("should allow the request", func() {
+			request = secrets.Request{ServiceName: "prometheus"}
+			config.DNSNames = []string{"prometheus.monitoring.internal"}
+			Expect(check()).To(Succeed())
+		})
+	})
+
+	When("Service matches a rule by label regex", func() {
+		It("should allow the request", func() {
+			request = secrets.Request{ServiceName: "api", ServiceLabels: map[string]string{"com.example.team": "billing"}}
+			Expect(check()).To(Succeed())
+		})
+	})
+
+	When("Service matches no rule", func() {
+		It("should deny the request", func() {
+			request = secrets.Request{ServiceName: "news_web", ServiceLabels: map[string]string{"com.docker.stack.namespace": "news"}}
+			Expect(check().Error()).To(Equal("service news_web is not allowed to use CA production, no requester rule matched"))
+		})
+	})
+
+	When("Rule is not valid", func() {
+		It("should return a parse error for invalid regex", func() {
+			_, err := policy.Parse([]byte(`{"cas": {"ca": {"requesters": [{"name": "bad", "service_regex": "("}]}}}`))
+			Expect(err.Error()).To(ContainSubstring("error parsing service regex of requester rule bad"))
+		})
+
+		It("should return a parse error for invalid IP ranges", func() {
+			_, err := policy.Parse([]byte(`{"cas": {"ca": {"requesters": [{"name": "bad", "allowed_ip_ranges": ["10.0.0.0"]}]}}}`))
+			Expect(err.Error()).To(Equal("invalid policy for CA ca: error parsing IP range of requester rule bad: 10.0.0.0"))
+		})
+
+		It("should return a parse error for unknown actions", func() {
+			_, err := policy.Parse([]byte(`{"cas": {"ca": {"requesters": [{"name": "bad", "action": "maybe"}]}}}`))
+			Expect(err.Error()).To(Equal("invalid policy for CA ca: unknown action of requester rule bad: maybe"))
+		})
+	})
+})